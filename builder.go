@@ -0,0 +1,78 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LoggerBuilder configures a [Logger] by chaining methods, see [Builder].
+// Options without a method can be set by Configure.
+type LoggerBuilder struct {
+	l Logger
+}
+
+// Builder creates a [LoggerBuilder] logging to l, with default [Config]:
+//
+//	l := Builder(log.Logger).
+//		SlowThreshold(200 * time.Millisecond).
+//		DumpAt(UseTrace).
+//		ErrorsAt(DebugCommonErr).
+//		Build()
+func Builder(l zerolog.Logger) *LoggerBuilder {
+	return &LoggerBuilder{l: Logger{Logger: l}}
+}
+
+// SlowThreshold sets SlowThreshold of [Config].
+func (b *LoggerBuilder) SlowThreshold(d time.Duration) *LoggerBuilder {
+	b.l.SlowThreshold = d
+	return b
+}
+
+// SlowAt sets SlowLevel of [Config].
+func (b *LoggerBuilder) SlowAt(level func(zerolog.Logger) *zerolog.Event) *LoggerBuilder {
+	b.l.SlowLevel = level
+	return b
+}
+
+// DumpAt sets DumpLevel of [Config].
+func (b *LoggerBuilder) DumpAt(level func(zerolog.Logger) *zerolog.Event) *LoggerBuilder {
+	b.l.DumpLevel = level
+	return b
+}
+
+// ErrorsAt sets ErrorLevel of [Config].
+func (b *LoggerBuilder) ErrorsAt(level func(error, zerolog.Logger) *zerolog.Event) *LoggerBuilder {
+	b.l.ErrorLevel = level
+	return b
+}
+
+// Customize sets Customize of [Config].
+func (b *LoggerBuilder) Customize(fn func(context.Context, *zerolog.Event)) *LoggerBuilder {
+	b.l.Customize = fn
+	return b
+}
+
+// Fields sets Fields of [Config].
+func (b *LoggerBuilder) Fields(fields map[string]any) *LoggerBuilder {
+	b.l.Fields = fields
+	return b
+}
+
+// Configure calls fn to modify [Config] directly, for options without a method.
+func (b *LoggerBuilder) Configure(fn func(*Config)) *LoggerBuilder {
+	fn(&b.l.Config)
+	return b
+}
+
+// Build creates the [Logger]. The builder can be reused, loggers built before
+// are not affected.
+func (b *LoggerBuilder) Build() *Logger {
+	l := b.l
+	return &l
+}
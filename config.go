@@ -5,8 +5,20 @@
 package gorm0log
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog"
 )
@@ -32,6 +44,58 @@ func UseDebug(l zerolog.Logger) *zerolog.Event { return l.Debug() }
 // UseTrace denotes specified message belongs to Trace level.
 func UseTrace(l zerolog.Logger) *zerolog.Event { return l.Trace() }
 
+// KeyPolicy denotes how fields with reserved keys are handled, see
+// ReservedKeyPolicy in [Config].
+type KeyPolicy int
+
+const (
+	// AllowReservedKeys writes fields as is, which might make duplicate keys.
+	AllowReservedKeys KeyPolicy = iota
+	// WarnReservedKeys drops conflicting fields, and lists their names in
+	// "reserved_key_conflicts".
+	WarnReservedKeys
+	// PrefixReservedKeys renames conflicting fields with "custom_" prefix.
+	PrefixReservedKeys
+)
+
+// LetterCase denotes how sql keywords are cased, see KeywordCase in [Config].
+type LetterCase int
+
+const (
+	PreserveCase LetterCase = iota // keeps keywords as is
+	UpperCase                      // changes keywords to upper case
+	LowerCase                      // changes keywords to lower case
+)
+
+// DurFormat denotes how a duration is logged.
+type DurFormat int
+
+const (
+	// FormatDur logs like the duration field, see [zerolog.DurationFieldUnit].
+	FormatDur DurFormat = iota
+	// FormatString logs as a string like "1.5s".
+	FormatString
+	// FormatMillis logs as a number of milliseconds.
+	FormatMillis
+)
+
+// DigestStyle denotes how fingerprint of sql is computed, see LogFingerprint in
+// [Config].
+type DigestStyle int
+
+const (
+	// GenericDigest replaces literals with "?", and collapses spaces and
+	// comments, like "SELECT * FROM t WHERE id IN (?,?)".
+	GenericDigest DigestStyle = iota
+	// MySQLDigest follows statement digest of MySQL additionally: keywords
+	// are upper cased, tokens are separated by single space, and lists of
+	// literals are collapsed, like "SELECT * FROM t WHERE id IN (...)". It is
+	// an approximation: identifiers are kept as is, so quote them like MySQL
+	// does (gorm does it by default) to match DIGEST_TEXT of
+	// performance_schema.
+	MySQLDigest
+)
+
 // Config is a switch for extra features of Logger.
 //
 // Default value is fairly enough for general use:
@@ -47,35 +111,398 @@ func UseTrace(l zerolog.Logger) *zerolog.Event { return l.Trace() }
 // By default, [gorm.DB.Debug] shows sql dump. This can be changed by setting
 // DumpLevel to [UseTrace] in [Config].
 type Config struct {
+	// Clock used to compute duration of queries and other time related
+	// features, default to [time.Now]. It helps testing slow sql messages.
+	Now func() time.Time
+
 	// Duration threshold of slow log, 0 or less disables it.
 	SlowThreshold time.Duration
 	// Log level of slow sql messages, default to [UseWarn].
 	SlowLevel func(zerolog.Logger) *zerolog.Event
+	// Makes sql dumping messages visible regardless of the log level for a
+	// while if errors spike, see [Escalator]. It has no effect if the logger
+	// is disabled or DumpLevel is [Ignore].
+	AutoEscalate *Escalator
+
+	// Threshold of time waited for connections of the pool during a query, 0
+	// or less disables it. It signals pressure on the pool rather than latency
+	// of the query itself, as waits of concurrent queries are counted too. It
+	// is logged at SlowLevel with "pool_wait" field, and needs the pool to be
+	// wrapped, see [Logger.ConnPool].
+	PoolWaitThreshold time.Duration
+
+	// Considers a query slow if it is slower than SlowPercentile of recent
+	// queries with same fingerprint (sql ignoring literal values), so
+	// regression of a query is caught relative to its normal behavior. It
+	// works with SlowThreshold, a query is slow if either is exceeded. A
+	// fingerprint needs 20 samples before judging. Sql is always built and
+	// recent durations are sorted for every query if it is set.
+	AdaptiveSlow bool
+	// Percentile used by AdaptiveSlow, from 0 to 1, default to 0.95.
+	SlowPercentile float64
+	// Tracker used by AdaptiveSlow, default to a process-wide tracker keeping
+	// 128 samples for 1024 fingerprints. If set, every query is recorded with
+	// slow queries counted, see [LatencyTracker.TopSlow], even if AdaptiveSlow
+	// is not set.
+	LatencyTracker *LatencyTracker
+
+	// Adds number of distinct tables referenced by sql, including those in
+	// subqueries, to every message of queries as "table_count", which
+	// indicates complexity of the query. Tables are detected by simple
+	// heuristic which skips aliases, subqueries in FROM clause and names
+	// defined by WITH.
+	LogTableCount bool
+	// Adds names of tables counted by LogTableCount as "tables" too.
+	LogTables bool
+
+	// Adds fingerprint of sql to every message of queries as "fingerprint",
+	// so queries different only in parameters can be grouped.
+	LogFingerprint bool
+	// How fingerprint is computed. It applies to LogFingerprint,
+	// LatencyTracker and EscalateAfter.
+	DigestStyle DigestStyle
+
+	// Logs the threshold exceeded in slow sql messages, which is
+	// SlowThreshold, or the percentile of AdaptiveSlow.
+	LogThreshold bool
+	// Key used to show the threshold, default to "threshold".
+	ThresholdKey string
+	// Format of the threshold, default to [FormatDur].
+	ThresholdFormat DurFormat
+
+	// Logs slow sql message even if an error message is logged for the query.
+	SlowOnError bool
+	// Omits execution time from slow sql messages. It is the opposite of
+	// options like DumpWithDuration, so zero value keeps the duration as
+	// before. Messages sharing fields of slow sql messages omit it too, which
+	// are "sql query consumes most of time budget" of DeadlineRatio, "sql query
+	// has no limit" of WarnMissingLimit and "sql query returns no rows" of
+	// EmptyReadLevel.
+	SlowWithoutDuration bool
+	// A function to estimate cost of slow queries, by running "EXPLAIN" for
+	// example. It is called only if slow sql message is visible, and result is
+	// logged in "plan_cost". If it fails, the error is logged in
+	// "plan_cost_error" instead. Do not run the query with a gorm session
+	// using this logger, or it might be logged recursively.
+	CostFunc func(ctx context.Context, sql string) (float64, error)
 	// Key used to show time tracking info, default to "duration"
 	Duration string
+	// Rounds time tracking info to a multiple of it, 0 or less disables it.
+	DurationRound time.Duration
+	// Upper bounds of latency buckets in ascending order. If set, label of the
+	// bucket which a query falls in is added to every message of queries as
+	// "latency_bucket", so log-based metrics can count queries per bucket.
+	DurationBuckets []time.Duration
+	// Labels of DurationBuckets. Label of i-th bucket is the i-th element, and
+	// label of queries exceeding every bucket is the element after last
+	// bucket. Missing labels are generated from bounds, like "le_10ms" or
+	// "le_inf".
+	LatencyBucketLabels []string
+	// Omits time tracking info if it is zero after rounding, which is common
+	// for cached or no-op operations.
+	OmitZeroDuration bool
+	// Key used to show time tracking info as float seconds, in addition to
+	// Duration. Empty string disables it.
+	DurationSecondsKey string
+	// Rounds DurationSecondsKey to this number of decimal places, 0 or less
+	// keeps full precision.
+	DurationSecondsPrecision int
 
 	// Log level for special error, default to log every error at Error level.
 	// You might use it to change log level of non-critical errors like
 	// [gorm.ErrRecordNotFound] or [gorm.ErrDuplicatedKey]. Helpers are
 	// provided, see [IgnoreCommonErr] and [DebugCommonErr].
 	ErrorLevel func(error, zerolog.Logger) *zerolog.Event
+	// Detects errors which are expected, like [CommonError]. Matched errors
+	// are logged with a distinct message at ExpectedLevel, instead of using
+	// ErrorLevel.
+	ExpectedError func(error) bool
+	// Log level of expected errors, default to [UseDebug].
+	ExpectedLevel func(zerolog.Logger) *zerolog.Event
+	// Logs an error at FirstErrorLevel until it occurs more than
+	// EscalateAfter times in EscalateWindow, so one-off blips are less noisy
+	// while persistent failures still get attention. 0 or less disables it.
+	//
+	// Errors are counted by class for each Logger, shared with loggers
+	// derived from it like [Logger.LogMode]. Class is the type of the error
+	// (like *pgconn.PgError) together with fingerprint of the sql (see
+	// LatencyTracker), so same failure of different parameters is counted
+	// together. A window starts at first occurrence of a class, and counting
+	// restarts after the window. Errors are only downgraded: it has no effect
+	// if level decided by options like ErrorLevel is lower than
+	// FirstErrorLevel, or the error is ignored.
+	EscalateAfter int
+	// Window of EscalateAfter, default to 1 minute.
+	EscalateWindow time.Duration
+	// Log level of errors before escalating, default to [UseWarn].
+	FirstErrorLevel func(zerolog.Logger) *zerolog.Event
+	// Log level for errors by type of sql statement, fallback to ErrorLevel.
+	// It takes precedence over ExpectedError.
+	// Key of the map is the first keyword of the statement in upper case, like
+	// "SELECT".
+	ErrorLevelByOp map[string]func(error, zerolog.Logger) *zerolog.Event
+	// Log level for connection errors (see [ConnectionError]). Connection
+	// errors are logged with a distinct message at this level if set,
+	// otherwise they are handled by ErrorLevel like other errors. Errors of
+	// [TooManyConnections] have their own message, so capacity issues can be
+	// alerted separately.
+	ConnErrorLevel func(zerolog.Logger) *zerolog.Event
+	// Logs message of every layer of wrapped error (see [errors.Unwrap]) as an
+	// array in "error_chain". At most 16 layers are logged.
+	LogErrorChain bool
+	// Adds execution time to error messages.
+	ErrorWithDuration bool
+	// Renders the error in error messages, instead of [zerolog.ErrorMarshalFunc]
+	// which is global. It is called only if the message is visible. The
+	// result is marshaled by [zerolog.InterfaceMarshalFunc].
+	ErrorMarshal func(error) any
+	// Fields logged only in error messages, like runbook url or team on call.
+	ErrorFields map[string]string
+	// Logs Go type of the error, like "*pq.Error", in ErrorTypeKey.
+	LogErrorType bool
+	// Key used to show type of error, default to "error_type".
+	ErrorTypeKey string
+	// Logs name of violated constraint in "constraint", see [ConstraintName].
+	LogConstraintName bool
+
+	// Logs a "query started" message at DumpLevel before executing a query,
+	// with a "query_id" field which is also added to other messages of the
+	// query. Only raw sql is logged in it as other sql is not built yet. It
+	// needs the logger to be registered as a plugin, see [Logger.Initialize].
+	LogStart bool
+
+	// Adds number of queries in flight when the query starts, including
+	// itself, to every message of queries as "in_flight", to correlate
+	// slowness with load. Queries are counted process-wide, from start to end
+	// of callbacks of gorm. It needs the logger to be registered as a plugin,
+	// see [Logger.Initialize].
+	LogInFlight bool
+
+	// Adds caller of the query to every message of queries, as "source_file"
+	// and "source_line", using same rules as gorm's default logger: first file
+	// not in gorm.io modules. Gorm does not pass its caller info to loggers,
+	// so it still walks the stack. Unlike [LogSource], it stops at first file
+	// outside gorm, which might be a wrapper around gorm like a repository in
+	// your code.
+	UseGormCaller bool
+
+	// Key used to show if the query succeeded, as a boolean in every message of
+	// queries regardless of log level. Empty string disables it.
+	StatusKey string
+
+	// Adds a process-wide, monotonically increasing sequence number to every
+	// message of queries, so messages logged in same millisecond can be
+	// ordered.
+	GlobalSequence bool
+	// Key used to show sequence number, default to "seq".
+	SequenceKey string
+
+	// Adds deadline info of context to every sql message if the context has a
+	// deadline: "time_to_deadline" is remaining time when the query finishes,
+	// which is negative if exceeded, and "deadline_exceeded" is a boolean.
+	LogDeadline bool
+	// If a query consumes more than this ratio of its time budget (duration
+	// between query start and context deadline), sql dumping message is logged
+	// at DeadlineLevel instead. 0 or less disables it.
+	DeadlineRatio float64
+	// Log level of queries exceeding DeadlineRatio, default to [UseWarn].
+	DeadlineLevel func(zerolog.Logger) *zerolog.Event
+
+	// Logs "stmt_cache" as "hit" or "miss" in every message of queries, to
+	// find cold prepared statement cache of gorm (see PrepareStmt in
+	// [gorm.Config]). Gorm does not tell if the cache is hit, so it is a best
+	// effort guess: a query is a hit if a query with same sql, ignoring
+	// literal values, has been executed by any logger in the process. At most
+	// 4096 statements are remembered and they are forgotten at once when
+	// full. Sql is always built if it is set.
+	LogStmtCache bool
+
+	// Logs a warning for SELECT statements without LIMIT clause, which might
+	// load unexpected large result set into memory. It is detected by simple
+	// heuristic which ignores statements with subquery, UNION or CTE, and
+	// statements selecting only aggregate functions.
+	WarnMissingLimit bool
+
+	// Log level of SELECT statements returning no rows, to spot cache misses
+	// or bad filters. They are logged with "empty_result" set to true, in
+	// addition to other messages. Unset disables it.
+	EmptyReadLevel func(zerolog.Logger) *zerolog.Event
 
 	// Do not log value of parameters.
 	ParameterizedQueries bool
+	// Adds "params_shown" to every message of queries, which is false if
+	// value of parameters is hidden by ParameterizedQueries, so redacted
+	// values can be told apart from absent ones.
+	LogParamsShown bool
+	// Adds "param_mismatch" set to true, and counts in "placeholders" and
+	// "params", to messages of queries whose number of placeholders ("?" or
+	// "$n") differs from number of parameters, which usually indicates a bug
+	// building the query. Gorm passes parameters to the logger only when
+	// building sql for logging, so it needs the logger to be registered as a
+	// plugin (see [Logger.Initialize]), and does nothing if no message is
+	// visible. Named parameters like "@name" are not supported.
+	WarnParamMismatch bool
+	// Logs sql without parameters substituted in "sql_template", in addition
+	// to the sql with parameters. It is logged only if the query has
+	// parameters and they are shown, see ParameterizedQueries. It needs the
+	// logger to be registered as a plugin, see [Logger.Initialize].
+	LogBothSQL bool
 
 	// Dump SQL
 	// Log level of sql dumping messages, default to [UseDebug].
 	DumpLevel func(zerolog.Logger) *zerolog.Event
+	// Decides if sql dumping messages of queries with the context are logged,
+	// like by checking a debug flag of the request. Error and slow sql
+	// messages, and audit messages of AuditWrites, are not affected. It is
+	// called only if the message is visible by log level. Nil means always.
+	DumpWhen func(context.Context) bool
+	// Logs only this fraction of sql dumping messages, chosen at random.
+	// Values out of (0, 1) log everything. Error, slow sql and audit messages,
+	// and queries marked by [ContextWithSampled] or during [Escalator] are not
+	// sampled.
+	DumpSampleRate float64
+	// Sample rates by type of sql statement, which take precedence over
+	// DumpSampleRate if the type is found. Key is the first keyword of the
+	// statement in upper case, like "SELECT".
+	SampleRateByOp map[string]float64
 	// Adds execution time info to sql dumping message.
 	DumpWithDuration bool
+	// Messages of sql dumping by type of sql statement, default to "dump sql".
+	// Key is the first keyword of the statement in upper case, like "SELECT"
+	// or "INSERT".
+	OperationMessages map[string]string
+	// Do not log sql dumping messages if sql is empty, which is generated by
+	// some no-op operations. Errors are always logged regardless.
+	SkipEmptySQL bool
+	// Adds "migration" field set to true to every message of schema operations,
+	// like those executed by [gorm.DB.AutoMigrate]. They are detected by
+	// statement keywords: DDL statements like CREATE or ALTER, and statements
+	// inspecting schema like SHOW, PRAGMA or SELECT from information_schema.
+	TagMigration bool
+	// Do not log sql dumping messages of schema operations, see TagMigration.
+	// Errors and slow sql are always logged regardless.
+	SkipMigration bool
+	// Omits sql from every message, including sql_template of LogBothSQL and
+	// "query started" message of LogStart. Other info like duration and
+	// affected rows are still logged. Sql is still used internally by features
+	// like OperationMessages.
+	OmitSQL bool
+	// Omits sql from sql dumping messages. Error and slow log messages still
+	// have it.
+	SQLOnProblemOnly bool
+	// Logs sql dumping messages of INSERT, UPDATE and DELETE statements at
+	// AuditLevel instead of DumpLevel, with an extra field "audit" set to
+	// true. Sql is always logged in such messages.
+	AuditWrites bool
+	// Log level of sql dumping messages of write statements if AuditWrites is
+	// set, default to [UseInfo].
+	AuditLevel func(zerolog.Logger) *zerolog.Event
+	// Changes case of common sql keywords in logged sql, so sql generated by
+	// different drivers can be matched reliably. Identifiers, including those
+	// named like keywords but quoted, and string literals are kept intact.
+	// Default to [PreserveCase].
+	KeywordCase LetterCase
+	// Replaces long column list of RETURNING clause with number of columns,
+	// like "RETURNING (…5 cols)", in logged sql. Lists with 3 or less columns
+	// are kept intact.
+	SummarizeReturning bool
+	// Functions to mask values of specific columns in logged sql, keyed by
+	// column name. Names are case-insensitive. Values are detected by a simple
+	// heuristic which finds "column = value" pairs, like conditions in WHERE
+	// and assignments in SET. Values of INSERT, IN lists or other operators
+	// are not masked, set ParameterizedQueries if you need to hide them. It is
+	// read once when the logger logs first message.
+	ColumnMaskers map[string]func(string) string
+	// Truncates logged sql to at most this bytes, 0 or less disables it.
+	MaxSQLLength int
+	// Text to denote truncated part of a value, default to "…". It is used by
+	// every truncation feature, like MaxSQLLength.
+	TruncateMarker string
+	// Keeps the tail of a value when truncating instead of the head.
+	TruncateKeepTail bool
 	// Key used to show sql dump, default to "sql".
 	SQL string
 	// Key used to show affected rows, default to "affected_rows".
 	AffectedRows string
+	// Logs affected rows as a string instead of a number, for log viewers which
+	// cannot handle large integers precisely.
+	AffectedRowsAsString bool
+	// Omits affected rows from every message, which takes precedence over
+	// other options about affected rows.
+	OmitAffectedRows bool
+	// Logs "returned_rows" for statements with RETURNING clause. Gorm exposes
+	// only one count to loggers, which is number of rows scanned for such
+	// statements, so it is same as affected rows. It helps telling returned
+	// rows from affected rows of other statements in bulk upserts, but rows
+	// affected without being returned cannot be detected.
+	LogReturnedRows bool
+	// Keys used to show affected rows by type of sql statement, fallback to
+	// AffectedRows. Key of the map is the first keyword of the statement in
+	// upper case, like "SELECT".
+	RowsKeyByOp map[string]string
+
+	// Logs messages from gorm (Info, Warn and Error of [logger.Interface]) as
+	// is, and arguments as an array in "args", instead of formatting them
+	// with [fmt.Sprintf]. It prevents artifacts like "%!d(MISSING)" if the
+	// message contains "%".
+	RawMessages bool
+
+	// Log level used by [Logger.LogMode] for [logger.Info] mode, zero value is
+	// [zerolog.DebugLevel]. Set it to [zerolog.TraceLevel] to show time
+	// tracking info with [gorm.DB.Debug].
+	InfoMapsTo zerolog.Level
+
+	// Name of sql dialect, logged in every message as "dialect" if set. The
+	// logger is created before gorm, so you have to set it yourself, using
+	// [gorm.Dialector.Name] for example.
+	Dialect string
+	// Fields logged in every message, see also [Logger.WithFields]. Fields set
+	// by [ContextWithFields] win if they have same name.
+	Fields map[string]any
+
+	// How to handle fields written by Customize functions, Fields or
+	// [ContextWithFields] with same name as fields written by the logger,
+	// like "sql" or "duration", which make duplicate keys. Default to
+	// [AllowReservedKeys]. Other policies encode those fields twice and parse
+	// them, so it costs some performance for every visible message.
+	ReservedKeyPolicy KeyPolicy
+	// Order of fields in messages of queries, like []string{"duration", "sql"}.
+	// Listed fields are written first in this order, and other fields follow
+	// in original order. Fields added by [zerolog.Context] always come first
+	// as they are encoded in advance. Fields are encoded twice and parsed if
+	// it is set, so it costs some performance for every visible message.
+	FieldOrder []string
+
+	// A function called for every query traced by the logger, regardless of
+	// log level, to collect metrics like [TableCounter]. Sql is always built
+	// if it is set.
+	OnQuery func(context.Context, QueryInfo)
+	// Detects customize functions writing to the event after they return,
+	// which corrupts or loses other messages. Customize functions write to a
+	// private event instead, fields are copied to the message, and late
+	// writes are reported with an Error message in next query of the same
+	// Logger or loggers derived from it. Events are checked once, and might
+	// not be watched at all as zerolog does not promise to reuse them, so it
+	// is done on a best-effort basis. It slows logging down, so use it in
+	// development only.
+	StrictEvents bool
 
 	// A function to log extra info, context value or call stacks for example.
 	// This function is called only if the message is visible.
+	//
+	// The event must not be used after the function returns, as it is reused
+	// by zerolog once the message is sent. It applies to other customize
+	// functions too, see StrictEvents.
 	Customize func(context.Context, *zerolog.Event)
+	// Functions like Customize, but called only for error, slow sql or sql
+	// dumping messages respectively, after Customize. Messages of queries
+	// consuming most of time budget (see DeadlineRatio) are considered slow.
+	ErrorCustomize func(context.Context, *zerolog.Event)
+	SlowCustomize  func(context.Context, *zerolog.Event)
+	DumpCustomize  func(context.Context, *zerolog.Event)
+
+	// adds kind of messages for TestLogger, see tagKind
+	captureKind bool
 }
 
 func key(val, defaults string) string {
@@ -94,14 +521,176 @@ func (c *Config) durKey() string { return key(c.Duration, "duration") }
 // json key to store affected rows
 func (c *Config) rowKey() string { return key(c.AffectedRows, "affected_rows") }
 
+// json key to store sequence number
+func (c *Config) seqKey() string { return key(c.SequenceKey, "seq") }
+
+// json key to store type of error
+func (c *Config) errTypeKey() string { return key(c.ErrorTypeKey, "error_type") }
+
+// default value of ErrorLevel, logs every error at Error level
+func defaultErrorLevel(_ error, l zerolog.Logger) *zerolog.Event { return UseError(l) }
+
+// json key to store affected rows of the sql
+func (c *Config) rowKeyOf(sql string) string {
+	if len(c.RowsKeyByOp) > 0 {
+		if k, ok := c.RowsKeyByOp[operation(sql)]; ok {
+			return k
+		}
+	}
+	return c.rowKey()
+}
+
 // log level of record not found message
 func (c *Config) errLevel(err error, l zerolog.Logger) *zerolog.Event {
 	if c.ErrorLevel == nil {
-		return UseError(l).Err(err)
+		return defaultErrorLevel(err, l)
 	}
 	return c.ErrorLevel(err, l)
 }
 
+// resolved returns a copy of c, with default values filled in.
+func (c Config) resolved() Config {
+	c.SlowLevel = level(c.SlowLevel, UseWarn)
+	c.DeadlineLevel = level(c.DeadlineLevel, UseWarn)
+	c.ExpectedLevel = level(c.ExpectedLevel, UseDebug)
+	c.DumpLevel = level(c.DumpLevel, UseDebug)
+	c.AuditLevel = level(c.AuditLevel, UseInfo)
+	c.FirstErrorLevel = level(c.FirstErrorLevel, UseWarn)
+	if c.SlowPercentile <= 0 || c.SlowPercentile > 1 {
+		c.SlowPercentile = 0.95
+	}
+	if c.EscalateWindow <= 0 {
+		c.EscalateWindow = time.Minute
+	}
+	if c.ErrorLevel == nil {
+		c.ErrorLevel = defaultErrorLevel
+	}
+	c.Duration = c.durKey()
+	c.SQL = c.sqlKey()
+	c.AffectedRows = c.rowKey()
+	c.SequenceKey = c.seqKey()
+	c.ErrorTypeKey = c.errTypeKey()
+	c.ThresholdKey = key(c.ThresholdKey, "threshold")
+	c.TruncateMarker = key(c.TruncateMarker, "…")
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+	return c
+}
+
+// levelProbe is a [zerolog.Sampler] which records level of the event and
+// samples it.
+type levelProbe struct {
+	lv     zerolog.Level
+	called bool
+}
+
+func (p *levelProbe) Sample(lv zerolog.Level) bool {
+	p.lv, p.called = lv, true
+	return true
+}
+
+// eventLevel finds log level of a level function by probing. Events are never
+// sent, and probe loggers never disable an event above its level, since zerolog
+// exits or panics when creating a disabled Fatal/Panic event, or sending an
+// enabled one.
+func eventLevel(fn func(zerolog.Logger) *zerolog.Event) zerolog.Level {
+	p := &levelProbe{}
+	if !fn(zerolog.New(io.Discard).Level(zerolog.TraceLevel).Sample(p)).Enabled() {
+		return zerolog.Disabled
+	}
+	if p.called {
+		return p.lv
+	}
+
+	// sampling is disabled by zerolog.DisableSampling, probes level by level.
+	// Panic level is reported as Fatal as it cannot be probed safely.
+	for lv := zerolog.DebugLevel; lv <= zerolog.FatalLevel; lv++ {
+		if !fn(zerolog.New(io.Discard).Level(lv)).Enabled() {
+			return lv - 1
+		}
+	}
+	return zerolog.FatalLevel
+}
+
+// Decide reports which kind of message [Logger.Trace] logs for a query, and at
+// which level, assuming every level is visible. It helps testing your config.
+//
+// Error message precedes slow sql message, which precedes sql dumping message.
+// Messages at [Ignore] are considered invisible and next kind is tested, but
+// slow sql message always hides sql dumping message.
+//
+// Features depending on sql or context, like ErrorLevelByOp, AuditWrites and
+// DeadlineRatio, and history like EscalateAfter, are not taken into account. If
+// SlowOnError is set, a slow sql message is also logged for slow failing queries
+// but only KindError is reported.
+func (c Config) Decide(err error, dur time.Duration) (MessageKind, zerolog.Level) {
+	if err != nil {
+		fn, _ := c.errLevelFn(err, nil)
+		if lv := eventLevel(fn); lv != zerolog.Disabled {
+			return KindError, lv
+		}
+	}
+
+	kind, lv := KindDump, eventLevel(c.dumpLevel)
+	if c.SlowThreshold > 0 && dur >= c.SlowThreshold {
+		kind, lv = KindSlow, eventLevel(c.slowLevel)
+	}
+	if lv == zerolog.Disabled {
+		return KindNone, lv
+	}
+	return kind, lv
+}
+
+// log level and message of sql error
+//
+// f might be nil if sql is not available.
+func (c *Config) errLevelFn(err error, f func() (string, int64)) (func(zerolog.Logger) *zerolog.Event, string) {
+	if c.ConnErrorLevel != nil && ConnectionError(err) {
+		if TooManyConnections(err) {
+			return c.ConnErrorLevel, "database connection limit reached"
+		}
+		return c.ConnErrorLevel, "a connection error occurred"
+	}
+	if len(c.ErrorLevelByOp) > 0 && f != nil {
+		sql, _ := f()
+		if fn, ok := c.ErrorLevelByOp[operation(sql)]; ok {
+			return func(l zerolog.Logger) *zerolog.Event { return fn(err, l) }, "a sql error occurred"
+		}
+	}
+	if c.ExpectedError != nil && c.ExpectedError(err) {
+		return c.expectedLevel, "an expected sql error occurred"
+	}
+	return func(l zerolog.Logger) *zerolog.Event { return c.errLevel(err, l) }, "a sql error occurred"
+}
+
+// downgrades level of error to FirstErrorLevel if err has not repeated enough,
+// see EscalateAfter
+func (l *Logger) firstError(fn func(zerolog.Logger) *zerolog.Event, err error, f func() (string, int64), now time.Time) func(zerolog.Logger) *zerolog.Event {
+	if l.EscalateAfter <= 0 {
+		return fn
+	}
+	lv := eventLevel(fn)
+	if lv == zerolog.Disabled {
+		return fn
+	}
+	window := l.EscalateWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+	sql, _ := f()
+	key := fmt.Sprintf("%T\x00%s", err, l.fingerprint(sql))
+	if l.states().repeats.add(key, now, window) > l.EscalateAfter {
+		return fn
+	}
+
+	first := level(l.FirstErrorLevel, UseWarn)
+	if eventLevel(first) >= lv {
+		return fn
+	}
+	return first
+}
+
 func level(val, defaults func(zerolog.Logger) *zerolog.Event) func(zerolog.Logger) *zerolog.Event {
 	if val == nil {
 		return defaults
@@ -114,54 +703,659 @@ func (c *Config) slowLevel(l zerolog.Logger) *zerolog.Event {
 	return level(c.SlowLevel, UseWarn)(l)
 }
 
+// log level of expected errors
+func (c *Config) expectedLevel(l zerolog.Logger) *zerolog.Event {
+	return level(c.ExpectedLevel, UseDebug)(l)
+}
+
+// log level of queries consuming most of time budget
+func (c *Config) deadlineLevel(l zerolog.Logger) *zerolog.Event {
+	return level(c.DeadlineLevel, UseWarn)(l)
+}
+
 // log level of sql dumping message
 func (c *Config) dumpLevel(l zerolog.Logger) *zerolog.Event {
 	return level(c.DumpLevel, UseDebug)(l)
 }
 
+// log level of audit message
+func (c *Config) auditLevel(l zerolog.Logger) *zerolog.Event {
+	return level(c.AuditLevel, UseInfo)(l)
+}
+
+// checks if sql dumping message of the query should be logged as audit message.
+// Sql is not built if neither message is visible in base.
+func (l *Logger) audit(base zerolog.Logger, f func() (string, int64)) bool {
+	if !l.AuditWrites {
+		return false
+	}
+	lv := l.dumpLevels()
+	if !levelVisible(base, lv.audit) && !levelVisible(base, lv.dump) {
+		return false
+	}
+	sql, _ := f()
+	switch operation(sql) {
+	case "INSERT", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
+// checks if sql dumping message is dropped by sampling
+func (c *Config) sampledOut(f func() (string, int64)) bool {
+	rate := c.DumpSampleRate
+	if len(c.SampleRateByOp) > 0 {
+		sql, _ := f()
+		if r, ok := c.SampleRateByOp[operation(sql)]; ok {
+			rate = r
+		}
+	}
+	if rate <= 0 || rate >= 1 {
+		return false
+	}
+	return rand.Float64() >= rate
+}
+
+// message of sql dumping
+func (c *Config) dumpMsg(sql string) string {
+	if msg, ok := c.OperationMessages[operation(sql)]; ok {
+		return msg
+	}
+	return "dump sql"
+}
+
+// writes static and context fields, context fields win. Fields bound by
+// WithContext are skipped.
+func (l *Logger) logFields(ctx context.Context, ev *zerolog.Event) {
+	fields := fieldsFrom(ctx)
+	switch {
+	case len(l.Fields) == 0 && len(fields) == 0:
+		return
+	case len(l.Fields) == 0:
+	case len(fields) == 0:
+		fields = l.Fields
+	default:
+		fields = mergeFields(l.Fields, fields)
+	}
+	if len(l.bound) > 0 {
+		arr := make(map[string]any, len(fields))
+		for k, v := range fields {
+			if !l.bound[k] {
+				arr[k] = v
+			}
+		}
+		fields = arr
+	}
+	ev.Fields(fields)
+}
+
 // calls cutsomizing function
-func (c *Config) custom(ctx context.Context) func(*zerolog.Event) {
+func (l *Logger) custom(ctx context.Context) func(*zerolog.Event) {
+	return l.guard(func(ev *zerolog.Event) {
+		if l.Dialect != "" {
+			ev.Str("dialect", l.Dialect)
+		}
+		l.logFields(ctx, ev)
+		if l.Customize == nil {
+			return
+		}
+		l.Customize(ctx, ev)
+	})
+}
+
+// keys written by the logger itself
+func (c *Config) reservedKeys() map[string]bool {
+	ret := map[string]bool{
+		zerolog.TimestampFieldName: true,
+		zerolog.LevelFieldName:     true,
+		zerolog.MessageFieldName:   true,
+		zerolog.ErrorFieldName:     true,
+		c.sqlKey():                 true,
+		"sql_template":             true,
+		c.durKey():                 true,
+		c.rowKey():                 true,
+		c.seqKey():                 true,
+		c.errTypeKey():             true,
+	}
+	if c.DurationSecondsKey != "" {
+		ret[c.DurationSecondsKey] = true
+	}
+	if c.LogThreshold {
+		ret[key(c.ThresholdKey, "threshold")] = true
+	}
+	if c.StatusKey != "" {
+		ret[c.StatusKey] = true
+	}
+	if c.LogFingerprint {
+		ret["fingerprint"] = true
+	}
+	if c.LogTableCount {
+		ret["table_count"] = true
+		if c.LogTables {
+			ret["tables"] = true
+		}
+	}
+	if c.LogParamsShown {
+		ret["params_shown"] = true
+	}
+	for _, k := range c.RowsKeyByOp {
+		ret[k] = true
+	}
+	return ret
+}
+
+// captures fields written by fn to an event without level in order, and passes
+// them to add
+func captureFields(fn func(*zerolog.Event), add func(key string, val json.RawMessage)) {
+	buf := &bytes.Buffer{}
+	capture := zerolog.New(buf)
+	capture.Log().Func(fn).Msg("")
+	parseFields(buf, add)
+}
+
+// parses fields of a json line written by an event without level
+func parseFields(r io.Reader, add func(key string, val json.RawMessage)) {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			return
+		}
+		k, _ := tok.(string)
+		add(k, val)
+	}
+}
+
+// applies fns in order, and reorders fields written by them by FieldOrder
+func (c *Config) ordered(fns ...func(*zerolog.Event)) func(*zerolog.Event) {
 	return func(ev *zerolog.Event) {
-		if c.Customize == nil {
+		if len(c.FieldOrder) == 0 {
+			for _, fn := range fns {
+				fn(ev)
+			}
 			return
 		}
-		c.Customize(ctx, ev)
+
+		var fields []jsonField
+		captureFields(func(x *zerolog.Event) {
+			for _, fn := range fns {
+				fn(x)
+			}
+		}, func(k string, val json.RawMessage) {
+			fields = append(fields, jsonField{key: k, val: val})
+		})
+
+		rank := make(map[string]int, len(c.FieldOrder))
+		for i, k := range c.FieldOrder {
+			if _, ok := rank[k]; !ok {
+				rank[k] = i
+			}
+		}
+		sort.SliceStable(fields, func(i, j int) bool {
+			a, okA := rank[fields[i].key]
+			b, okB := rank[fields[j].key]
+			if okA && okB {
+				return a < b
+			}
+			return okA && !okB
+		})
+		for _, f := range fields {
+			ev.RawJSON(f.key, f.val)
+		}
+	}
+}
+
+// applies ReservedKeyPolicy and StrictEvents to fields written by fn
+func (l *Logger) guard(fn func(*zerolog.Event)) func(*zerolog.Event) {
+	if l.ReservedKeyPolicy == AllowReservedKeys && !l.StrictEvents {
+		return fn
+	}
+	capture := captureFields
+	if l.StrictEvents {
+		capture = l.states().late.capture
+	}
+	return func(ev *zerolog.Event) {
+		reserved := l.reservedKeys()
+		var conflicts []string
+		capture(fn, func(k string, val json.RawMessage) {
+			if l.ReservedKeyPolicy != AllowReservedKeys && reserved[k] {
+				conflicts = append(conflicts, k)
+				if l.ReservedKeyPolicy != PrefixReservedKeys {
+					return
+				}
+				k = "custom_" + k
+			}
+			ev.RawJSON(k, val)
+		})
+		if len(conflicts) > 0 && l.ReservedKeyPolicy == WarnReservedKeys {
+			ev.Strs("reserved_key_conflicts", conflicts)
+		}
+	}
+}
+
+// memoizes f, so sql is built only once even if multiple messages are logged
+func once(f func() (string, int64)) func() (string, int64) {
+	var (
+		sql  string
+		rows int64
+		done bool
+	)
+	return func() (string, int64) {
+		if !done {
+			sql, rows = f()
+			done = true
+		}
+		return sql, rows
+	}
+}
+
+// writes time tracking info
+func (c *Config) logDur(ev *zerolog.Event, dur time.Duration) {
+	if c.DurationRound > 0 {
+		dur = dur.Round(c.DurationRound)
+	}
+	if c.OmitZeroDuration && dur == 0 {
+		return
+	}
+	ev.Dur(c.durKey(), dur)
+	if c.DurationSecondsKey != "" {
+		sec := dur.Seconds()
+		if c.DurationSecondsPrecision > 0 {
+			p := math.Pow10(c.DurationSecondsPrecision)
+			sec = math.Round(sec*p) / p
+		}
+		ev.Float64(c.DurationSecondsKey, sec)
+	}
+}
+
+// current time
+func (c *Config) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// reports if value of parameters is shown, see [Logger.ParamsFilter]
+func (c *Config) paramsShown() bool {
+	return !c.ParameterizedQueries
+}
+
+// fingerprint of sql in DigestStyle
+func (c *Config) fingerprint(sql string) string {
+	if c.DigestStyle == MySQLDigest {
+		return mysqlDigest(sql)
+	}
+	return fingerprint(sql)
+}
+
+// EffectiveSlowThreshold reports the fixed threshold of slow sql message for a
+// query of op (first keyword like "SELECT") on table, executed with ctx. 0
+// means no fixed threshold applies. It is resolved in order:
+//
+//  1. 0 if slow log is disabled by [ContextWithoutSlowLog], which also
+//     disables AdaptiveSlow.
+//  2. SlowThreshold if it is greater than 0.
+//  3. 0 otherwise.
+//
+// AdaptiveSlow judges a query in addition to the fixed threshold, by recent
+// durations of its fingerprint, so it is not reflected. Current options do not
+// depend on op and table.
+func (c Config) EffectiveSlowThreshold(ctx context.Context, op, table string) time.Duration {
+	return c.slowThreshold(ctx)
+}
+
+// fixed threshold of slow log, see EffectiveSlowThreshold
+func (c *Config) slowThreshold(ctx context.Context) time.Duration {
+	if slowLogDisabled(ctx) || c.SlowThreshold <= 0 {
+		return 0
+	}
+	return c.SlowThreshold
+}
+
+// checks if a query is slow, and returns the threshold exceeded
+func (c *Config) isSlow(ctx context.Context, dur time.Duration, f func() (string, int64)) (bool, time.Duration) {
+	th := c.slowThreshold(ctx)
+	slow := th > 0 && dur >= th
+	t := c.LatencyTracker
+	if t == nil && c.AdaptiveSlow {
+		t = defaultTracker
+	}
+	if t == nil {
+		return slow, th
+	}
+
+	p := -1.0
+	if c.AdaptiveSlow && !slowLogDisabled(ctx) {
+		p = c.SlowPercentile
+		if p <= 0 || p > 1 {
+			p = 0.95
+		}
+	}
+	sql, _ := f()
+	adaptive, limit := t.record(c.fingerprint(sql), dur, slow, p)
+	if slow {
+		return true, th
+	}
+	return adaptive, limit
+}
+
+// writes threshold exceeded by slow query
+func (c *Config) logThreshold(th time.Duration) func(*zerolog.Event) {
+	return func(ev *zerolog.Event) {
+		if !c.LogThreshold {
+			return
+		}
+		k := key(c.ThresholdKey, "threshold")
+		switch c.ThresholdFormat {
+		case FormatString:
+			ev.Str(k, th.String())
+		case FormatMillis:
+			ev.Float64(k, float64(th)/float64(time.Millisecond))
+		default:
+			ev.Dur(k, th)
+		}
+	}
+}
+
+// checks if a query consumes too much of its time budget
+func (c *Config) nearDeadline(ctx context.Context, begin time.Time, dur time.Duration) bool {
+	if c.DeadlineRatio <= 0 {
+		return false
+	}
+	t, ok := ctx.Deadline()
+	if !ok {
+		return false
+	}
+	budget := t.Sub(begin)
+	if budget <= 0 {
+		return true
+	}
+	return float64(dur)/float64(budget) >= c.DeadlineRatio
+}
+
+// ColumnMaskers with lower case keys
+func (c *Config) maskers() map[string]func(string) string {
+	ret := make(map[string]func(string) string, len(c.ColumnMaskers))
+	for k, v := range c.ColumnMaskers {
+		ret[strings.ToLower(k)] = v
+	}
+	return ret
+}
+
+// truncates s to at most n bytes (marker excluded), on rune boundary
+func (c *Config) truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	marker := key(c.TruncateMarker, "…")
+	if c.TruncateKeepTail {
+		idx := len(s) - n
+		for idx < len(s) && !utf8.RuneStart(s[idx]) {
+			idx++
+		}
+		return marker + s[idx:]
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
+	return s[:n] + marker
+}
+
+// formats sql to be logged
+func (l *Logger) formatSQL(sql string) string {
+	if l.KeywordCase != PreserveCase {
+		sql = recase(sql, l.KeywordCase == UpperCase)
+	}
+	if l.SummarizeReturning {
+		sql = summarizeReturning(sql)
+	}
+	if len(l.ColumnMaskers) > 0 {
+		sql = maskColumns(sql, l.states().masks)
+	}
+	if l.MaxSQLLength > 0 {
+		sql = l.truncate(sql, l.MaxSQLLength)
+	}
+	return sql
+}
+
+// writes sql to the event, st might be nil
+func (l *Logger) logSQL(ev *zerolog.Event, sql string, st *queryState) {
+	if l.OmitSQL {
+		return
+	}
+	ev.Str(l.sqlKey(), l.formatSQL(sql))
+	if st != nil && st.template != "" {
+		ev.Str("sql_template", l.formatSQL(st.template))
+	}
+}
+
+// process-wide sequence number of messages, see GlobalSequence
+var sequence atomic.Int64
+
+// format of fields common to every message of a query
+func (c *Config) logQuery(ctx context.Context, begin time.Time, dur time.Duration, f func() (string, int64), err error) func(*zerolog.Event) {
+	// every query is recorded, even if no message is visible
+	cache := ""
+	if c.LogStmtCache {
+		sql, _ := f()
+		cache = stmtCache(sql)
+	}
+	return func(ev *zerolog.Event) {
+		if c.StatusKey != "" {
+			ev.Bool(c.StatusKey, err == nil)
+		}
+		if cache != "" {
+			ev.Str("stmt_cache", cache)
+		}
+		if c.LogParamsShown {
+			ev.Bool("params_shown", c.paramsShown())
+		}
+		if c.LogFingerprint {
+			sql, _ := f()
+			ev.Str("fingerprint", c.fingerprint(sql))
+		}
+		if c.LogTableCount {
+			sql, _ := f()
+			arr := tables(sql)
+			ev.Int("table_count", len(arr))
+			if c.LogTables {
+				ev.Strs("tables", arr)
+			}
+		}
+		if c.TagMigration {
+			if sql, _ := f(); isMigration(sql) {
+				ev.Bool("migration", true)
+			}
+		}
+		st := stateFrom(ctx)
+		if st != nil && c.LogStart {
+			ev.Int64("query_id", st.id)
+		}
+		if st != nil && c.LogInFlight && st.inFlight > 0 {
+			ev.Int64("in_flight", st.inFlight)
+		}
+		if st != nil && c.WarnParamMismatch {
+			// ensures ParamsFilter is called
+			f()
+			if st.checked && st.placeholders != st.params {
+				ev.Bool("param_mismatch", true).
+					Int("placeholders", st.placeholders).
+					Int("params", st.params)
+			}
+		}
+		if c.GlobalSequence {
+			ev.Int64(c.seqKey(), sequence.Add(1))
+		}
+		if c.UseGormCaller {
+			if file, line, ok := gormCaller(); ok {
+				ev.Str("source_file", file).Int("source_line", line)
+			}
+		}
+		if len(c.DurationBuckets) > 0 {
+			ev.Str("latency_bucket", c.latencyBucket(dur))
+		}
+		c.logDeadline(ev, ctx, begin.Add(dur))
+	}
+}
+
+// label of the bucket which dur falls in
+func (c *Config) latencyBucket(dur time.Duration) string {
+	i := sort.Search(len(c.DurationBuckets), func(i int) bool {
+		return dur <= c.DurationBuckets[i]
+	})
+	if i < len(c.LatencyBucketLabels) {
+		return c.LatencyBucketLabels[i]
+	}
+	if i == len(c.DurationBuckets) {
+		return "le_inf"
+	}
+	return "le_" + strings.ReplaceAll(c.DurationBuckets[i].String(), ".", "_")
+}
+
+// writes affected rows to the event, if any
+func (c *Config) logRows(ev *zerolog.Event, sql string, rows int64) {
+	if rows == -1 || c.OmitAffectedRows {
+		return
+	}
+	if c.LogReturnedRows && hasReturning(sql) {
+		ev.Int64("returned_rows", rows)
+	}
+	if c.AffectedRowsAsString {
+		ev.Str(c.rowKeyOf(sql), strconv.FormatInt(rows, 10))
+		return
+	}
+	ev.Int64(c.rowKeyOf(sql), rows)
+}
+
+// format of deadline info
+func (c *Config) logDeadline(ev *zerolog.Event, ctx context.Context, end time.Time) {
+	if !c.LogDeadline {
+		return
+	}
+	t, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	left := t.Sub(end)
+	ev.Dur("time_to_deadline", left).Bool("deadline_exceeded", left < 0)
+}
+
+// max layers logged with LogErrorChain
+const maxErrorChain = 16
+
+// messages of every layer of err
+func errorChain(err error) []string {
+	ret := make([]string, 0, 4)
+	for ; err != nil && len(ret) < maxErrorChain; err = errors.Unwrap(err) {
+		ret = append(ret, err.Error())
+	}
+	return ret
+}
+
+// calls customizing function of specific type of message
+func (l *Logger) customizeBy(ctx context.Context, fn func(context.Context, *zerolog.Event)) func(*zerolog.Event) {
+	return l.guard(func(ev *zerolog.Event) {
+		if fn != nil {
+			fn(ctx, ev)
+		}
+	})
+}
+
+// sorted keys of ErrorFields
+func (c *Config) errorFieldKeys() []string {
+	if len(c.ErrorFields) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(c.ErrorFields))
+	for k := range c.ErrorFields {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
 }
 
 // format of error log message
-func (c *Config) logErr(err error, f func() (string, int64)) func(*zerolog.Event) {
+func (l *Logger) logErr(err error, dur time.Duration, f func() (string, int64), st *queryState) func(*zerolog.Event) {
 	return func(ev *zerolog.Event) {
 		sql, rows := f()
-		ev.Err(err).Str(c.sqlKey(), sql)
-		if rows != -1 {
-			ev.Int64(c.rowKey(), rows)
+		if l.ErrorWithDuration {
+			l.logDur(ev, dur)
+		}
+		if l.ErrorMarshal != nil {
+			ev.Interface(zerolog.ErrorFieldName, l.ErrorMarshal(err))
+		} else {
+			ev.Err(err)
+		}
+		for _, k := range l.errorFieldKeys() {
+			ev.Str(k, l.ErrorFields[k])
 		}
+		l.logSQL(ev, sql, st)
+		if l.LogErrorChain {
+			ev.Strs("error_chain", errorChain(err))
+		}
+		if l.LogErrorType {
+			ev.Str(l.errTypeKey(), fmt.Sprintf("%T", err))
+		}
+		if l.LogConstraintName {
+			if name := ConstraintName(err); name != "" {
+				ev.Str("constraint", name)
+			}
+		}
+		l.logRows(ev, sql, rows)
 	}
 }
 
 // format of slow log message
-func (c *Config) logSlow(dur time.Duration, f func() (string, int64)) func(*zerolog.Event) {
+func (l *Logger) logSlow(dur time.Duration, f func() (string, int64), st *queryState) func(*zerolog.Event) {
 	return func(ev *zerolog.Event) {
 		sql, rows := f()
-		ev.Dur(c.durKey(), dur).Str(c.sqlKey(), sql)
-		if rows != -1 {
-			ev.Int64(c.rowKey(), rows)
+		if !l.SlowWithoutDuration {
+			l.logDur(ev, dur)
+		}
+		l.logSQL(ev, sql, st)
+		l.logRows(ev, sql, rows)
+	}
+}
+
+// estimated cost of slow query
+func (c *Config) logCost(ctx context.Context, f func() (string, int64)) func(*zerolog.Event) {
+	return func(ev *zerolog.Event) {
+		if c.CostFunc == nil {
+			return
+		}
+		sql, _ := f()
+		cost, err := c.CostFunc(ctx, sql)
+		if err != nil {
+			ev.Str("plan_cost_error", err.Error())
+			return
 		}
+		ev.Float64("plan_cost", cost)
 	}
 }
 
 // format of sql dumping message
-func (c *Config) logDump(dur time.Duration, f func() (string, int64)) func(*zerolog.Event) {
+func (l *Logger) logDump(dur time.Duration, f func() (string, int64), audit bool, st *queryState) func(*zerolog.Event) {
 	return func(ev *zerolog.Event) {
-		if c.DumpWithDuration {
-			ev.Dur(c.durKey(), dur)
+		if audit {
+			ev.Bool("audit", true)
+		}
+		if l.DumpWithDuration {
+			l.logDur(ev, dur)
 		}
 
 		sql, rows := f()
-		ev.Str(c.sqlKey(), sql)
-		if rows != -1 {
-			ev.Int64(c.rowKey(), rows)
+		if audit || !l.SQLOnProblemOnly {
+			l.logSQL(ev, sql, st)
 		}
+		l.logRows(ev, sql, rows)
 	}
 }
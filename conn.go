@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConnPool wraps db to detect pressure on the pool, by measuring time waited for
+// connections of the pool during each query. It is logged with a distinct
+// message if it exceeds PoolWaitThreshold in [Config]. Slow queries are
+// sometimes caused by an exhausted pool, which cannot be told apart by
+// [Logger.Trace].
+//
+// Replace the pool of gorm after opening it:
+//
+//	sqlDB, _ := db.DB()
+//	db.ConnPool = l.ConnPool(sqlDB)
+//	db.Statement.ConnPool = db.ConnPool
+//
+// Or pass it to the dialector, like Conn in Config of gorm's postgres driver.
+//
+// Queries are executed by db as is, and wait time is measured by WaitDuration
+// of [sql.DBStats] before and after each call. The pool waits only if
+// MaxOpenConns is reached, and time to open new connections is not counted. As
+// the stats are shared by the pool, time waited by concurrent queries is counted
+// too, so it is not checkout latency of the query itself. Queries are not bound
+// to a connection, so retrying on bad connections of database/sql still works.
+func (l *Logger) ConnPool(db *sql.DB) gorm.ConnPool {
+	return &connPool{db: db, l: l}
+}
+
+type connPool struct {
+	db *sql.DB
+	l  *Logger
+}
+
+// logs time waited for connections of the pool if it is too long
+func (l *Logger) poolWait(ctx context.Context, dur time.Duration) {
+	if l.PoolWaitThreshold <= 0 || dur < l.PoolWaitThreshold {
+		return
+	}
+	ctx = withLevel(ctx, l.slowLevel)
+	l.tagKind(l.slowLevel(l.Logger), KindSlow).
+		Func(l.custom(ctx)).
+		Func(l.customizeBy(ctx, l.SlowCustomize)).
+		Dur("pool_wait", dur).
+		Msg("sql connection pool is busy")
+}
+
+// total time waited for connections of the pool
+func (p *connPool) waited() time.Duration {
+	return p.db.Stats().WaitDuration
+}
+
+// logs time waited for connections since before, see waited
+func (p *connPool) measure(ctx context.Context, before time.Duration) {
+	p.l.poolWait(ctx, p.waited()-before)
+}
+
+func (p *connPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	defer p.measure(ctx, p.waited())
+	return p.db.PrepareContext(ctx, query)
+}
+
+func (p *connPool) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer p.measure(ctx, p.waited())
+	return p.db.ExecContext(ctx, query, args...)
+}
+
+func (p *connPool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer p.measure(ctx, p.waited())
+	return p.db.QueryContext(ctx, query, args...)
+}
+
+func (p *connPool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer p.measure(ctx, p.waited())
+	return p.db.QueryRowContext(ctx, query, args...)
+}
+
+// BeginTx implements [gorm.TxBeginner].
+func (p *connPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	defer p.measure(ctx, p.waited())
+	return p.db.BeginTx(ctx, opts)
+}
+
+// GetDBConn implements [gorm.GetDBConnector], so [gorm.DB.DB] works.
+func (p *connPool) GetDBConn() (*sql.DB, error) { return p.db, nil }
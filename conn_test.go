@@ -0,0 +1,62 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestConnPool(t *testing.T) {
+	l, buf := bufLogger(Config{PoolWaitThreshold: time.Millisecond})
+	db := openDB(t, l)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("cannot get sql.DB: %v", err)
+	}
+	db.ConnPool = l.ConnPool(sqlDB)
+	db.Statement.ConnPool = db.ConnPool
+
+	var x int
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Raw("SELECT 1").Row().Scan(&x)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = db.Raw("SELECT 1").Scan(&x).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = db.DB(); err != nil {
+		t.Fatalf("cannot get sql.DB from wrapped pool: %v", err)
+	}
+
+	// the only connection is busy
+	sqlDB.SetMaxOpenConns(1)
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("cannot get connection: %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		conn.Close()
+	}()
+	if err = db.Exec("SELECT 1").Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waits := 0
+	for _, line := range parseLines(t, buf) {
+		if _, ok := line["pool_wait"]; ok {
+			waits++
+		}
+	}
+	if waits != 1 {
+		t.Errorf("expected 1 pool_wait message, got %d: %s", waits, buf.String())
+	}
+}
@@ -0,0 +1,354 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type startKey struct{}
+
+// ContextWithStart saves start time of a request into context, which is used by
+// [LogElapsed].
+func ContextWithStart(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, startKey{}, t)
+}
+
+// LogElapsed creates a function to be used as Customize of [Config].
+//
+// It writes time elapsed since the start time saved by [ContextWithStart] to
+// specified field. Nothing is written if there's no start time in context.
+func LogElapsed(field string) func(context.Context, *zerolog.Event) {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		t, ok := ctx.Value(startKey{}).(time.Time)
+		if !ok {
+			return
+		}
+		ev.Dur(field, time.Since(t))
+	}
+}
+
+type fieldsKey struct{}
+
+// ContextWithFields saves fields into context, which are logged in every message
+// of queries executed with the context.
+//
+// Fields are merged with those already in ctx, later one wins if there are
+// fields with same name. They also override fields set by [Logger.WithFields].
+func ContextWithFields(ctx context.Context, fields map[string]any) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, mergeFields(fieldsFrom(ctx), fields))
+}
+
+func fieldsFrom(ctx context.Context) map[string]any {
+	ret, _ := ctx.Value(fieldsKey{}).(map[string]any)
+	return ret
+}
+
+// merges two maps into a new one, fields in b win
+func mergeFields(a, b map[string]any) map[string]any {
+	ret := make(map[string]any, len(a)+len(b))
+	for k, v := range a {
+		ret[k] = v
+	}
+	for k, v := range b {
+		ret[k] = v
+	}
+	return ret
+}
+
+type summaryKey struct{}
+
+// summary aggregates queries executed with a context.
+type summary struct {
+	queries atomic.Int64
+	slow    atomic.Int64
+	errors  atomic.Int64
+	dur     atomic.Int64
+}
+
+func (s *summary) add(dur time.Duration, slow bool, err error) {
+	s.queries.Add(1)
+	s.dur.Add(int64(dur))
+	if slow {
+		s.slow.Add(1)
+	}
+	if err != nil {
+		s.errors.Add(1)
+	}
+}
+
+// records a query to the summary in ctx, if any
+func addSummary(ctx context.Context, dur time.Duration, slow bool, err error) {
+	if s, ok := ctx.Value(summaryKey{}).(*summary); ok {
+		s.add(dur, slow, err)
+	}
+}
+
+// ContextWithSummary creates a context which aggregates queries executed with it
+// (via [gorm.DB.WithContext]), and a function to log the summary.
+//
+// The function writes total queries, total time spent, number of slow queries
+// and number of failed queries to the event and sends it. It is safe to use
+// the context concurrently. You usually call it at the end of a request, in a
+// middleware for example:
+//
+//	ctx, flush := ContextWithSummary(r.Context())
+//	defer flush(log.Info())
+func ContextWithSummary(ctx context.Context) (context.Context, func(*zerolog.Event)) {
+	s := &summary{}
+	return context.WithValue(ctx, summaryKey{}, s), func(ev *zerolog.Event) {
+		ev.Int64("queries", s.queries.Load()).
+			Dur("db_time", time.Duration(s.dur.Load())).
+			Int64("slow_queries", s.slow.Load()).
+			Int64("failed_queries", s.errors.Load()).
+			Msg("sql summary")
+	}
+}
+
+type noSlowLogKey struct{}
+
+// ContextWithoutSlowLog creates a context which disables slow sql message for
+// queries executed with it, like intentionally long statements of background
+// jobs. Errors and sql dumping messages are still logged, and such queries are
+// not counted as slow by [ContextWithSummary].
+func ContextWithoutSlowLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noSlowLogKey{}, true)
+}
+
+// reports if slow sql message is disabled by ContextWithoutSlowLog
+func slowLogDisabled(ctx context.Context) bool {
+	ret, _ := ctx.Value(noSlowLogKey{}).(bool)
+	return ret
+}
+
+type readOnlyKey struct{}
+
+// ContextWithReadOnly marks the context as using a read-only transaction, which
+// is used by [LogReadOnly]. Gorm does not tell if a transaction is read-only, so
+// you have to mark it yourself when starting one:
+//
+//	ctx = ContextWithReadOnly(ctx)
+//	err := db.WithContext(ctx).Transaction(fn, &sql.TxOptions{ReadOnly: true})
+func ContextWithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// LogReadOnly creates a function to be used as Customize of [Config].
+//
+// It writes true to specified field if the context is marked by
+// [ContextWithReadOnly]. Nothing is written otherwise.
+func LogReadOnly(field string) func(context.Context, *zerolog.Event) {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		if ro, _ := ctx.Value(readOnlyKey{}).(bool); ro {
+			ev.Bool(field, true)
+		}
+	}
+}
+
+// RequestIDHeader is the http header used by [RequestIDFromHeader] to find
+// request id.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID saves request id into context, which is used by
+// [LogRequestID].
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromHeader finds request id from [RequestIDHeader] of the response
+// header (set by request id middlewares) or the request header.
+//
+// There are no adapters for web frameworks, so this module does not depend on
+// them. Pass headers of the framework you use instead.
+//
+// Feed it to [ContextWithRequestID] in a middleware, and query with the request
+// context. With gin:
+//
+//	r.Use(func(c *gin.Context) {
+//		id := RequestIDFromHeader(c.Writer.Header(), c.Request.Header)
+//		ctx := ContextWithRequestID(c.Request.Context(), id)
+//		c.Request = c.Request.WithContext(ctx)
+//		c.Next()
+//	})
+//
+// With echo:
+//
+//	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+//		return func(c echo.Context) error {
+//			r := c.Request()
+//			id := RequestIDFromHeader(c.Response().Header(), r.Header)
+//			c.SetRequest(r.WithContext(ContextWithRequestID(r.Context(), id)))
+//			return next(c)
+//		}
+//	})
+func RequestIDFromHeader(resp, req http.Header) string {
+	if id := resp.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return req.Get(RequestIDHeader)
+}
+
+// LogRequestID creates a function to be used as Customize of [Config].
+//
+// It writes request id saved by [ContextWithRequestID] to specified field.
+// Nothing is written if there's no request id in context.
+func LogRequestID(field string) func(context.Context, *zerolog.Event) {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+			ev.Str(field, id)
+		}
+	}
+}
+
+type labelsKey struct{}
+
+// label is a key-value pair set by ContextWithLabels.
+type label struct{ key, val string }
+
+// ContextWithLabels saves labels into context, which are written as string fields
+// by [LogLabels]. It is like [ContextWithFields], but intended for few low
+// cardinality dimensions like route or api version.
+//
+// Labels are merged with those already in ctx, later one wins if there are
+// labels with same name.
+func ContextWithLabels(ctx context.Context, labels map[string]string) context.Context {
+	m := map[string]string{}
+	for _, l := range labelsFrom(ctx) {
+		m[l.key] = l.val
+	}
+	for k, v := range labels {
+		m[k] = v
+	}
+
+	arr := make([]label, 0, len(m))
+	for k, v := range m {
+		arr = append(arr, label{key: k, val: v})
+	}
+	sort.Slice(arr, func(i, j int) bool { return arr[i].key < arr[j].key })
+	return context.WithValue(ctx, labelsKey{}, arr)
+}
+
+func labelsFrom(ctx context.Context) []label {
+	ret, _ := ctx.Value(labelsKey{}).([]label)
+	return ret
+}
+
+// LogLabels is a function to be used as Customize of [Config]. It writes every
+// label saved by [ContextWithLabels] as a string field, sorted by name.
+func LogLabels(ctx context.Context, ev *zerolog.Event) {
+	for _, l := range labelsFrom(ctx) {
+		ev.Str(l.key, l.val)
+	}
+}
+
+type sampledKey struct{}
+
+// ContextWithSampled saves sampling decision of distributed tracing into context.
+// If sampled is true, sql dumping messages of queries executed with the context
+// are visible regardless of the log level, unless the logger is disabled or
+// DumpLevel is [Ignore].
+//
+// It is usually set by a middleware according to incoming trace headers.
+func ContextWithSampled(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, sampledKey{}, sampled)
+}
+
+func sampled(ctx context.Context) bool {
+	ret, _ := ctx.Value(sampledKey{}).(bool)
+	return ret
+}
+
+type txIDKey struct{}
+
+// ContextWithTxID generates a random id and saves it into context, which is used
+// by [LogTxID]. Executing a transaction with the context makes every statement of
+// it share the id, so they can be grouped even if interleaved with others:
+//
+//	err := db.WithContext(ContextWithTxID(ctx)).Transaction(func(tx *gorm.DB) error {
+//		// use tx, which carries the context, for every statement
+//	})
+//
+// Statements using other sessions inside the function, like the outer db, do
+// not have the id.
+func ContextWithTxID(ctx context.Context) context.Context {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return context.WithValue(ctx, txIDKey{}, hex.EncodeToString(buf[:]))
+}
+
+// LogTxID creates a function to be used as Customize of [Config].
+//
+// It writes transaction id saved by [ContextWithTxID] to specified field.
+// Nothing is written if there's no transaction id in context.
+func LogTxID(field string) func(context.Context, *zerolog.Event) {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		if id, _ := ctx.Value(txIDKey{}).(string); id != "" {
+			ev.Str(field, id)
+		}
+	}
+}
+
+type accumulatorKey struct{}
+
+// FieldAccumulator collects fields during a request, see [ContextWithAccumulator].
+// It is safe for concurrent use.
+type FieldAccumulator struct {
+	lock   sync.Mutex
+	fields map[string]any
+}
+
+// Set adds a field, or replaces the field with same name.
+func (a *FieldAccumulator) Set(key string, val any) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.fields[key] = val
+}
+
+// Fields returns a copy of accumulated fields.
+func (a *FieldAccumulator) Fields() map[string]any {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return mergeFields(nil, a.fields)
+}
+
+// ContextWithAccumulator creates a context with an empty [FieldAccumulator],
+// which is used by [LogAccumulated]. Fields set to it are logged in every
+// message of queries executed with the context after that, so you can add info
+// progressively during a request:
+//
+//	ctx, acc := ContextWithAccumulator(r.Context())
+//	db.WithContext(ctx).First(&user) // no extra field
+//	acc.Set("cache_hit", false)
+//	db.WithContext(ctx).Find(&orders) // with cache_hit
+//
+// The accumulator lives as long as the context, usually a request. Messages
+// of queries running concurrently see fields set before they are logged.
+func ContextWithAccumulator(ctx context.Context) (context.Context, *FieldAccumulator) {
+	a := &FieldAccumulator{fields: map[string]any{}}
+	return context.WithValue(ctx, accumulatorKey{}, a), a
+}
+
+// LogAccumulated is a function to be used as Customize of [Config]. It writes
+// fields accumulated by [FieldAccumulator] in context, if any.
+func LogAccumulated(ctx context.Context, ev *zerolog.Event) {
+	a, ok := ctx.Value(accumulatorKey{}).(*FieldAccumulator)
+	if !ok {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	ev.Fields(a.fields)
+}
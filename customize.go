@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// CustomizeFunc is type of Customize in [Config] and helpers like [LogRequestID].
+// Combinators like [Chain] and [When] build one from others:
+//
+//	Customize: Chain(
+//		LogRequestID("req_id"),
+//		When(isAdmin, LogLabels),
+//		OnlyLevel(zerolog.WarnLevel, LogElapsed("elapsed")),
+//	)
+type CustomizeFunc = func(context.Context, *zerolog.Event)
+
+// Chain creates a [CustomizeFunc] which calls fns in order. Nil functions are
+// skipped.
+func Chain(fns ...CustomizeFunc) CustomizeFunc {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		for _, fn := range fns {
+			if fn != nil {
+				fn(ctx, ev)
+			}
+		}
+	}
+}
+
+// When creates a [CustomizeFunc] which calls fn only if pred reports true for
+// the context.
+func When(pred func(context.Context) bool, fn CustomizeFunc) CustomizeFunc {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		if pred(ctx) {
+			fn(ctx, ev)
+		}
+	}
+}
+
+// OnlyLevel creates a [CustomizeFunc] which calls fn only for messages at lv or
+// higher level, to add costly info like call stacks to important messages.
+// Level of the message is passed by the context, so fields bound by
+// [Logger.WithContext], which apply to every level, are skipped.
+func OnlyLevel(lv zerolog.Level, fn CustomizeFunc) CustomizeFunc {
+	return func(ctx context.Context, ev *zerolog.Event) {
+		if x, ok := levelFrom(ctx); ok && x >= lv {
+			fn(ctx, ev)
+		}
+	}
+}
+
+type levelKey struct{}
+
+// withLevel passes level of the message being built to customize functions,
+// level is resolved only if needed.
+func withLevel(ctx context.Context, fn func(zerolog.Logger) *zerolog.Event) context.Context {
+	return context.WithValue(ctx, levelKey{}, fn)
+}
+
+// levelFrom reports level of the message being built, see withLevel
+func levelFrom(ctx context.Context) (zerolog.Level, bool) {
+	fn, ok := ctx.Value(levelKey{}).(func(zerolog.Logger) *zerolog.Event)
+	if !ok {
+		return zerolog.NoLevel, false
+	}
+	return eventLevel(fn), true
+}
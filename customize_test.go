@@ -0,0 +1,55 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestCustomizeFunc(t *testing.T) {
+	mark := func(key string) CustomizeFunc {
+		return func(_ context.Context, ev *zerolog.Event) { ev.Bool(key, true) }
+	}
+	hasReq := func(ctx context.Context) bool { return ctx.Value(requestIDKey{}) != nil }
+	l, buf := bufLogger(Config{Customize: Chain(
+		mark("a"),
+		nil,
+		When(hasReq, mark("req")),
+		OnlyLevel(zerolog.ErrorLevel, mark("important")),
+	)})
+	f := func() (string, int64) { return "SELECT 1", -1 }
+
+	l.Trace(context.Background(), time.Now(), f, nil)
+	l.Trace(ContextWithRequestID(context.Background(), "x"), time.Now(), f, errors.New("test"))
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["a"] != true || lines[0]["req"] != nil || lines[0]["important"] != nil {
+		t.Errorf("unexpected fields of dump message: %v", lines[0])
+	}
+	if lines[1]["a"] != true || lines[1]["req"] != true || lines[1]["important"] != true {
+		t.Errorf("unexpected fields of error message: %v", lines[1])
+	}
+}
+
+func TestRequestIDFromHeader(t *testing.T) {
+	resp, req := http.Header{}, http.Header{}
+	req.Set(RequestIDHeader, "req")
+	if id := RequestIDFromHeader(resp, req); id != "req" {
+		t.Errorf("expected id from request, got %q", id)
+	}
+	resp.Set(RequestIDHeader, "resp")
+	if id := RequestIDFromHeader(resp, req); id != "resp" {
+		t.Errorf("expected id from response, got %q", id)
+	}
+}
@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fallbackLogger delegates messages ignored by primary to fallback.
+type fallbackLogger struct {
+	primary  *Logger
+	fallback logger.Interface
+}
+
+// WithFallback creates a [logger.Interface] which logs with primary, and
+// delegates a message to fallback if primary ignores it. It eases migrating from
+// another gorm logger.
+//
+// Fallback is triggered when:
+//
+//   - Info, Warn or Error is called and the level is invisible in primary.
+//   - Trace is called and primary logs nothing for the query. It is the case if
+//     every message is invisible, or is skipped by options like SkipEmptySQL.
+//
+// LogMode changes both loggers. [gorm.ParamsFilter] is delegated to primary, and
+// the returned logger can be registered as a plugin like [Logger.Initialize].
+func WithFallback(primary *Logger, fallback logger.Interface) logger.Interface {
+	return &fallbackLogger{primary: primary, fallback: fallback}
+}
+
+// checks if messages at lv are visible in l
+func (l *Logger) enabled(lv zerolog.Level) bool {
+	return levelVisible(l.Logger, lv)
+}
+
+func (l *fallbackLogger) LogMode(lv logger.LogLevel) logger.Interface {
+	return &fallbackLogger{
+		primary:  l.primary.LogMode(lv).(*Logger),
+		fallback: l.fallback.LogMode(lv),
+	}
+}
+
+func (l *fallbackLogger) Info(ctx context.Context, msg string, args ...any) {
+	if !l.primary.enabled(zerolog.InfoLevel) {
+		l.fallback.Info(ctx, msg, args...)
+		return
+	}
+	l.primary.Info(ctx, msg, args...)
+}
+
+func (l *fallbackLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if !l.primary.enabled(zerolog.WarnLevel) {
+		l.fallback.Warn(ctx, msg, args...)
+		return
+	}
+	l.primary.Warn(ctx, msg, args...)
+}
+
+func (l *fallbackLogger) Error(ctx context.Context, msg string, args ...any) {
+	if !l.primary.enabled(zerolog.ErrorLevel) {
+		l.fallback.Error(ctx, msg, args...)
+		return
+	}
+	l.primary.Error(ctx, msg, args...)
+}
+
+func (l *fallbackLogger) Trace(ctx context.Context, begin time.Time, f func() (string, int64), err error) {
+	// sql is built only once even if both loggers use it
+	f = once(f)
+	if !l.primary.trace(ctx, begin, f, err) {
+		l.fallback.Trace(ctx, begin, f, err)
+	}
+}
+
+func (l *fallbackLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	return l.primary.ParamsFilter(ctx, sql, params...)
+}
+
+func (l *fallbackLogger) Name() string { return l.primary.Name() }
+
+func (l *fallbackLogger) Initialize(db *gorm.DB) error { return l.primary.Initialize(db) }
@@ -0,0 +1,58 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm/logger"
+)
+
+// counts calls of each method
+type countLogger struct {
+	logger.Interface
+	infos, traces int
+}
+
+func (l *countLogger) LogMode(logger.LogLevel) logger.Interface { return l }
+
+func (l *countLogger) Info(context.Context, string, ...any) { l.infos++ }
+
+func (l *countLogger) Trace(context.Context, time.Time, func() (string, int64), error) {
+	l.traces++
+}
+
+func TestWithFallback(t *testing.T) {
+	f := func() (string, int64) { return "SELECT 1", 1 }
+	ctx := context.Background()
+
+	p, buf := bufLogger(Config{})
+	p.Logger = p.Logger.Level(zerolog.WarnLevel)
+	fb := &countLogger{}
+	l := WithFallback(p, fb)
+
+	l.Info(ctx, "info")
+	l.Trace(ctx, time.Now(), f, nil)
+	if fb.infos != 1 || fb.traces != 1 {
+		t.Errorf("expected fallback to be called, got %+v", fb)
+	}
+
+	l.Warn(ctx, "warn")
+	l.Trace(ctx, time.Now(), f, context.Canceled)
+	if fb.traces != 1 {
+		t.Errorf("expected fallback not to be called, got %+v", fb)
+	}
+	if lines := parseLines(t, buf); len(lines) != 2 {
+		t.Errorf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+
+	l.LogMode(logger.Info).Trace(ctx, time.Now(), f, nil)
+	if fb.traces != 1 {
+		t.Errorf("expected fallback not to be called in Info mode, got %+v", fb)
+	}
+}
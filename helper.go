@@ -6,9 +6,16 @@ package gorm0log
 
 import (
 	"context"
+	"database/sql/driver"
 	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
@@ -17,7 +24,8 @@ import (
 // LogSource creates a function to provide caller info.
 //
 // It iterates the stack to find fist file that matches any of keywords, puts
-// filename and line number to json field "source_file" and "source_line".
+// filename and line number to json field "source_file" and "source_line". See
+// [LogCaller] for more fields.
 func LogSource(keywords ...string) func(context.Context, *zerolog.Event) {
 	return func(_ context.Context, ev *zerolog.Event) {
 		fn, line, ok := "", 0, true
@@ -40,6 +48,115 @@ func LogSource(keywords ...string) func(context.Context, *zerolog.Event) {
 	}
 }
 
+// directory of this package, initialized like gorm does
+var selfDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.ToSlash(filepath.Dir(file)) + "/"
+}()
+
+// gormCaller finds caller of a query using rules of gorm's default logger, see
+// gormFile.
+func gormCaller() (string, int, bool) {
+	fr, ok := callerFrame(gormFile)
+	return fr.File, fr.Line, ok
+}
+
+// gormFile reports if file might be caller of a query using rules of gorm's
+// default logger, which skips files in gorm.io modules unless it is a test file,
+// and generated files. Files of this package and zerolog are skipped too.
+func gormFile(file string) bool {
+	internal := inModule(file, "gorm.io/") ||
+		inModule(file, "github.com/rs/zerolog") ||
+		strings.HasPrefix(file, selfDir)
+	return (!internal || strings.HasSuffix(file, "_test.go")) &&
+		!strings.HasSuffix(file, ".gen.go")
+}
+
+// inModule reports if file is in module path, which is the beginning of file
+// when built with -trimpath, or a path segment in module cache or GOPATH.
+func inModule(file, path string) bool {
+	return strings.HasPrefix(file, path) || strings.Contains(file, "/"+path)
+}
+
+// callerFrame finds first frame in the stack whose file is accepted by match.
+func callerFrame(match func(file string) bool) (runtime.Frame, bool) {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		fr, more := frames.Next()
+		if fr.File != "" && match(fr.File) {
+			return fr, true
+		}
+		if !more {
+			return runtime.Frame{}, false
+		}
+	}
+}
+
+// splitFunc splits full name of a function into import path of the package and
+// short name, like "example.com/app/repo" and "(*Repo).Find".
+func splitFunc(name string) (pkg, short string) {
+	slash := strings.LastIndex(name, "/") + 1
+	dot := strings.Index(name[slash:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+dot], name[slash+dot+1:]
+}
+
+// CallerOptions selects fields logged by [LogCaller]. Every field is opt-in.
+type CallerOptions struct {
+	// Finds first file matching any of keywords like [LogSource]. Caller is
+	// found like UseGormCaller in [Config] if empty.
+	Keywords []string
+
+	File bool // logs file name as "source_file"
+	Line bool // logs line number as "source_line"
+	Func bool // logs function name without package as "source_func"
+	Pkg  bool // logs import path of the package as "source_pkg"
+}
+
+// LogCaller creates a function to provide caller info, which can be used as
+// Customize in [Config]. Fields are selected by opts, and the stack is walked
+// only once for all of them:
+//
+//	Customize: LogCaller(CallerOptions{File: true, Line: true, Func: true})
+func LogCaller(opts CallerOptions) func(context.Context, *zerolog.Event) {
+	match := gormFile
+	if len(opts.Keywords) > 0 {
+		match = func(file string) bool {
+			for _, kw := range opts.Keywords {
+				if strings.Contains(file, kw) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(_ context.Context, ev *zerolog.Event) {
+		if !(opts.File || opts.Line || opts.Func || opts.Pkg) {
+			return
+		}
+		fr, ok := callerFrame(match)
+		if !ok {
+			return
+		}
+		if opts.File {
+			ev.Str("source_file", fr.File)
+		}
+		if opts.Line {
+			ev.Int("source_line", fr.Line)
+		}
+		pkg, short := splitFunc(fr.Function)
+		if opts.Func {
+			ev.Str("source_func", short)
+		}
+		if opts.Pkg {
+			ev.Str("source_pkg", pkg)
+		}
+	}
+}
+
 // LogErrorAt creates a function to be used at ErrorLevel of [Config]. It compares
 // error using cmpErr, use specified level to log it if matched, Error level
 // otherwise.
@@ -52,11 +169,299 @@ func LogErrorAt(level func(zerolog.Logger) *zerolog.Event, cmpErr func(error) bo
 	}
 }
 
+// ErrorRule pairs an error matcher with a log level, see [LogErrorAtMulti].
+type ErrorRule struct {
+	Match func(error) bool
+	Level func(zerolog.Logger) *zerolog.Event
+}
+
+// LogErrorAtMulti is like [LogErrorAt], but checks multiple rules in order. The
+// first matched rule decides the log level, Error level if none matched.
+//
+// For example, to log canceled queries at Info level and timed out ones at Warn
+// level:
+//
+//	ErrorLevel: LogErrorAtMulti(
+//		ErrorRule{Match: ContextCanceled, Level: UseInfo},
+//		ErrorRule{Match: ContextDeadline, Level: UseWarn},
+//	)
+func LogErrorAtMulti(rules ...ErrorRule) func(error, zerolog.Logger) *zerolog.Event {
+	return func(err error, l zerolog.Logger) *zerolog.Event {
+		for _, r := range rules {
+			if r.Match(err) {
+				return r.Level(l)
+			}
+		}
+		return UseError(l)
+	}
+}
+
+// ContextCanceled detects if err is [context.Canceled], which usually means
+// the client has gone away.
+func ContextCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// ContextDeadline detects if err is [context.DeadlineExceeded], which usually
+// means the query is too slow.
+func ContextDeadline(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
 // CommonError detects if err is [gorm.ErrDuplicatedKey] or [gorm.ErrRecordNotFound].
 func CommonError(err error) bool {
 	return errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, gorm.ErrDuplicatedKey)
 }
 
+// CommonErrorWith creates a function like [CommonError], but also detects extra
+// errors you specified.
+func CommonErrorWith(extra ...error) func(error) bool {
+	return func(err error) bool {
+		if CommonError(err) {
+			return true
+		}
+		for _, e := range extra {
+			if errors.Is(err, e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ErrorContains creates a function to detect if message of an error contains any
+// of substrings, case-insensitively. It can be used with [LogErrorAt] or
+// [LogErrorAtMulti].
+//
+// Error messages vary between drivers and versions, so use it only if the driver
+// does not provide typed errors.
+func ErrorContains(substrings ...string) func(error) bool {
+	arr := make([]string, len(substrings))
+	for idx, s := range substrings {
+		arr[idx] = strings.ToLower(s)
+	}
+
+	return func(err error) bool {
+		if err == nil {
+			return false
+		}
+		msg := strings.ToLower(err.Error())
+		for _, s := range arr {
+			if strings.Contains(msg, s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ConnectionError detects if err is caused by a broken or unavailable database
+// connection, like [driver.ErrBadConn], [io.EOF] or connection refused/reset.
+//
+// Some drivers do not wrap network errors, so it also checks error message for
+// few well-known phrases.
+func ConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, e := range []error{
+		driver.ErrBadConn,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		net.ErrClosed,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.EPIPE,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	return connMessage(err) || TooManyConnections(err)
+}
+
+// TooManyConnections detects if err is caused by connection limit of database,
+// like SQLSTATE 53300 of PostgreSQL or error 1040 of MySQL. It is an operational
+// issue rather than a bug, and is also a [ConnectionError].
+//
+// Like [ConstraintName], it checks fields of driver errors (Code of postgres
+// drivers and Number of mysql driver) without importing drivers, and falls back
+// to error message.
+func TooManyConnections(err error) bool {
+	return driverCode(err, "53300", 1040) || tooManyConnMessage(err)
+}
+
+// driverCode reports if any layer of err is a driver error with code (Code of
+// postgres drivers) or number (Number of mysql driver).
+func driverCode(err error, code string, number uint64) bool {
+	return driverError(err, func(v reflect.Value) bool {
+		if f := v.FieldByName("Code"); f.IsValid() && f.Kind() == reflect.String && f.String() == code {
+			return true
+		}
+		f := v.FieldByName("Number")
+		return f.IsValid() && f.CanUint() && f.Uint() == number
+	})
+}
+
+var tooManyConnMessage = ErrorContains(
+	"too many connections",
+	"too many clients",
+	"remaining connection slots are reserved",
+)
+
+var connMessage = ErrorContains(
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"invalid connection",
+)
+
+// DatabaseBusy detects if err is caused by lock contention, like SQLITE_BUSY of
+// SQLite or lock wait timeout (1205) of MySQL. Such errors are usually handled
+// by retrying.
+func DatabaseBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// sqlite drivers provide result code, extended code in higher bits
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		c, ok := e.(interface{ Code() int })
+		if !ok || !sqliteType(e) {
+			continue
+		}
+		if c := c.Code() & 0xff; c == 5 || c == 6 { // SQLITE_BUSY, SQLITE_LOCKED
+			return true
+		}
+	}
+
+	return busyMessage(err)
+}
+
+// sqliteType reports if type of e is defined in a sqlite driver, like
+// modernc.org/sqlite. Other drivers use same codes for unrelated errors.
+func sqliteType(e error) bool {
+	t := reflect.TypeOf(e)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return strings.Contains(t.PkgPath(), "sqlite")
+}
+
+var busyMessage = ErrorContains(
+	"database is locked",
+	"database table is locked",
+	"sqlite_busy",
+	"lock wait timeout exceeded",
+)
+
+// Deadlock detects if err is caused by a deadlock, like SQLSTATE 40P01 of
+// PostgreSQL or error 1213 of MySQL. The database has chosen this transaction
+// as the victim and rolled it back, so it is usually fixed by retrying the
+// whole transaction. Driver errors are checked like [TooManyConnections].
+//
+// Route it and [LockWaitTimeout] to different levels to tell retry issues from
+// long transactions:
+//
+//	ErrorLevel: LogErrorAtMulti(
+//		ErrorRule{Match: Deadlock, Level: UseWarn},
+//		ErrorRule{Match: LockWaitTimeout, Level: UseError},
+//	)
+func Deadlock(err error) bool {
+	return driverCode(err, "40P01", 1213) || deadlockMessage(err)
+}
+
+var deadlockMessage = ErrorContains(
+	"deadlock detected",
+	"deadlock found",
+)
+
+// LockWaitTimeout detects if err is caused by timeout waiting for a lock, like
+// SQLSTATE 55P03 of PostgreSQL (lock_timeout or NOWAIT) or error 1205 of MySQL.
+// No victim is chosen, it usually indicates another transaction holds locks for
+// too long, so reducing scope of transactions helps more than retrying. Driver
+// errors are checked like [TooManyConnections].
+func LockWaitTimeout(err error) bool {
+	return driverCode(err, "55P03", 1205) || lockTimeoutMessage(err)
+}
+
+var lockTimeoutMessage = ErrorContains(
+	"lock wait timeout exceeded",
+	"canceling statement due to lock timeout",
+	"could not obtain lock",
+)
+
+// Retryable detects if err is a transient error which might success if you
+// retry, see [ConnectionError], [DatabaseBusy], [Deadlock] and
+// [LockWaitTimeout].
+func Retryable(err error) bool {
+	return ConnectionError(err) || DatabaseBusy(err) || Deadlock(err) || LockWaitTimeout(err)
+}
+
+// driverError calls fn with every layer of err which is a struct or pointer to
+// struct, until fn returns true. Drivers are inspected by reflection so they
+// are not imported.
+func driverError(err error, fn func(reflect.Value) bool) bool {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		v := reflect.ValueOf(e)
+		for v.Kind() == reflect.Pointer && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct && fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields holding constraint name in driver errors, like pgconn.PgError and
+// pq.Error
+var constraintFields = []string{"ConstraintName", "Constraint"}
+
+// patterns to find constraint name in error messages of mysql and sqlite
+var constraintPatterns = []*regexp.Regexp{
+	regexp.MustCompile("CONSTRAINT `([^`]+)`"),
+	regexp.MustCompile(`(?i)check constraint '([^']+)' is violated`),
+	regexp.MustCompile(`(?i)violates \w+(?: \w+)? constraint "([^"]+)"`),
+	regexp.MustCompile(`(?i)check constraint failed: (\w+)`),
+}
+
+// ConstraintName extracts name of violated constraint from err, or returns empty
+// string if not found.
+//
+// It finds a string field named ConstraintName or Constraint in every layer of
+// err, which is provided by common postgres drivers, so drivers are not imported.
+// Messages of mysql, sqlite and postgres are parsed if no such field is found.
+func ConstraintName(err error) string {
+	var ret string
+	found := driverError(err, func(v reflect.Value) bool {
+		for _, name := range constraintFields {
+			f := v.FieldByName(name)
+			if f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+				ret = f.String()
+				return true
+			}
+		}
+		return false
+	})
+	if found || err == nil {
+		return ret
+	}
+	msg := err.Error()
+	for _, re := range constraintPatterns {
+		if m := re.FindStringSubmatch(msg); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
 // IgnoreCommonErr is shortcut of LogErrorAt(UseTrace, CommonError).
 func IgnoreCommonErr(e error, l zerolog.Logger) *zerolog.Event {
 	return LogErrorAt(UseTrace, CommonError)(e, l)
@@ -0,0 +1,284 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// logs a message using level function, returns the level name
+func levelOf(t *testing.T, err error, f func(error, zerolog.Logger) *zerolog.Event) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	f(err, zerolog.New(buf).Level(zerolog.TraceLevel)).Msg("test")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("cannot parse log: %v", err)
+	}
+	lv, _ := m[zerolog.LevelFieldName].(string)
+	return lv
+}
+
+func TestContextErrors(t *testing.T) {
+	canceled := fmt.Errorf("query: %w", context.Canceled)
+	deadline := fmt.Errorf("query: %w", fmt.Errorf("wrapped: %w", context.DeadlineExceeded))
+	other := errors.New("other")
+
+	cases := []struct {
+		name     string
+		err      error
+		canceled bool
+		deadline bool
+		level    string
+	}{
+		{name: "canceled", err: canceled, canceled: true, level: "info"},
+		{name: "deadline", err: deadline, deadline: true, level: "warn"},
+		{name: "other", err: other, level: "error"},
+	}
+
+	f := LogErrorAtMulti(
+		ErrorRule{Match: ContextCanceled, Level: UseInfo},
+		ErrorRule{Match: ContextDeadline, Level: UseWarn},
+	)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if x := ContextCanceled(c.err); x != c.canceled {
+				t.Errorf("ContextCanceled: expected %v, got %v", c.canceled, x)
+			}
+			if x := ContextDeadline(c.err); x != c.deadline {
+				t.Errorf("ContextDeadline: expected %v, got %v", c.deadline, x)
+			}
+			if x := levelOf(t, c.err, f); x != c.level {
+				t.Errorf("level: expected %s, got %s", c.level, x)
+			}
+		})
+	}
+}
+
+func TestCommonErrorWith(t *testing.T) {
+	errCustom := errors.New("custom")
+	f := CommonErrorWith(errCustom)
+	cases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "not found", err: gorm.ErrRecordNotFound, expect: true},
+		{name: "wrapped custom", err: fmt.Errorf("wrapped: %w", errCustom), expect: true},
+		{name: "other", err: errors.New("custom")},
+		{name: "nil", err: nil},
+	}
+
+	for _, c := range cases {
+		if actual := f(c.err); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expect, actual)
+		}
+	}
+}
+
+func TestErrorContains(t *testing.T) {
+	f := ErrorContains("Duplicate Entry", "unique constraint")
+	cases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "case-insensitive", err: errors.New("Error 1062: duplicate entry 'a' for key 'name'"), expect: true},
+		{name: "wrapped", err: fmt.Errorf("create: %w", errors.New("UNIQUE constraint failed")), expect: true},
+		{name: "other", err: errors.New("syntax error")},
+		{name: "nil", err: nil},
+	}
+
+	for _, c := range cases {
+		if actual := f(c.err); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expect, actual)
+		}
+	}
+	if level := levelOf(t, errors.New("duplicate entry"), LogErrorAt(UseInfo, f)); level != "info" {
+		t.Errorf("expected info level with LogErrorAt, got %s", level)
+	}
+}
+
+// mimics pgconn.PgError
+type pgError struct {
+	Code           string
+	Message        string
+	ConstraintName string
+}
+
+func (e *pgError) Error() string { return e.Message }
+
+func TestConstraintName(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		expect string
+	}{
+		{name: "field", err: fmt.Errorf("wrapped: %w", &pgError{Message: "x", ConstraintName: "fk_user"}), expect: "fk_user"},
+		{name: "postgres", err: errors.New(`ERROR: insert or update on table "orders" violates foreign key constraint "fk_user" (SQLSTATE 23503)`), expect: "fk_user"},
+		{name: "mysql fk", err: errors.New("Error 1452 (23000): Cannot add or update a child row: a foreign key constraint fails (`db`.`orders`, CONSTRAINT `fk_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"), expect: "fk_user"},
+		{name: "mysql check", err: errors.New("Error 3819 (HY000): Check constraint 'chk_age' is violated."), expect: "chk_age"},
+		{name: "sqlite check", err: errors.New("CHECK constraint failed: chk_age"), expect: "chk_age"},
+		{name: "sqlite unique", err: errors.New("UNIQUE constraint failed: users.name"), expect: ""},
+		{name: "nil", err: nil, expect: ""},
+	}
+
+	for _, c := range cases {
+		if actual := ConstraintName(c.err); actual != c.expect {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expect, actual)
+		}
+	}
+}
+
+// mimics mysql.MySQLError
+type mysqlError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *mysqlError) Error() string { return e.Message }
+
+func TestTooManyConnections(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "mysql", err: fmt.Errorf("wrapped: %w", &mysqlError{Number: 1040, Message: "x"}), expect: true},
+		{name: "other mysql", err: &mysqlError{Number: 1062, Message: "duplicate"}, expect: false},
+		{name: "postgres message", err: errors.New("FATAL: sorry, too many clients already (SQLSTATE 53300)"), expect: true},
+		{name: "other", err: errors.New("syntax error"), expect: false},
+		{name: "nil", err: nil, expect: false},
+	}
+
+	for _, c := range cases {
+		if actual := TooManyConnections(c.err); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expect, actual)
+		}
+		if c.expect && !ConnectionError(c.err) {
+			t.Errorf("%s: expected to be a connection error", c.name)
+		}
+	}
+}
+
+func TestLogCaller(t *testing.T) {
+	l, buf := bufLogger(Config{Customize: LogCaller(CallerOptions{
+		Line: true,
+		Func: true,
+		Pkg:  true,
+	})})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	line := lines[0]
+	if _, ok := line["source_file"]; ok {
+		t.Errorf("unexpected source_file: %v", line)
+	}
+	if line["source_func"] != "TestLogCaller" || line["source_pkg"] != "github.com/raohwork/gorm0log" {
+		t.Errorf("unexpected caller: %v", line)
+	}
+	if n, _ := line["source_line"].(float64); n == 0 {
+		t.Errorf("expected source_line, got %v", line)
+	}
+}
+
+func TestGormFile(t *testing.T) {
+	cases := []struct {
+		file   string
+		expect bool
+	}{
+		{file: "/root/go/pkg/mod/gorm.io/gorm@v1.25.9/callbacks.go"},
+		{file: "gorm.io/gorm@v1.25.9/callbacks.go"}, // -trimpath
+		{file: "github.com/rs/zerolog@v1.32.0/log.go"},
+		{file: "gorm.io/gorm@v1.25.9/tests/query_test.go", expect: true},
+		{file: "example.com/app/repo/user.go", expect: true},
+		{file: "example.com/app/repo/user.gen.go"},
+		{file: "example.com/notgorm.io/user.go", expect: true},
+	}
+
+	for _, c := range cases {
+		if actual := gormFile(c.file); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.file, c.expect, actual)
+		}
+	}
+}
+
+func TestSplitFunc(t *testing.T) {
+	pkg, short := splitFunc("example.com/app/repo.(*Repo).Find.func1")
+	if pkg != "example.com/app/repo" || short != "(*Repo).Find.func1" {
+		t.Errorf("unexpected result: %s, %s", pkg, short)
+	}
+	if pkg, short = splitFunc("main.main"); pkg != "main" || short != "main" {
+		t.Errorf("unexpected result: %s, %s", pkg, short)
+	}
+}
+
+// codeError mimics errors of drivers with a Code method, but not sqlite
+type codeError int
+
+func (e codeError) Error() string { return fmt.Sprintf("code %d", int(e)) }
+func (e codeError) Code() int     { return int(e) }
+
+func TestDatabaseBusy(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "sqlite message", err: fmt.Errorf("wrapped: %w", errors.New("database is locked (5) (SQLITE_BUSY)")), expect: true},
+		{name: "mysql lock timeout", err: errors.New("Error 1205: Lock wait timeout exceeded"), expect: true},
+		{name: "code of other driver", err: codeError(5)},
+		{name: "locked code of other driver", err: fmt.Errorf("wrapped: %w", codeError(6))},
+		{name: "nil", err: nil},
+	}
+
+	for _, c := range cases {
+		if actual := DatabaseBusy(c.err); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expect, actual)
+		}
+	}
+}
+
+func TestLockErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		deadlock bool
+		timeout  bool
+	}{
+		{name: "pg deadlock", err: fmt.Errorf("wrapped: %w", &pgError{Code: "40P01", Message: "x"}), deadlock: true},
+		{name: "pg lock timeout", err: &pgError{Code: "55P03", Message: "x"}, timeout: true},
+		{name: "mysql deadlock", err: &mysqlError{Number: 1213, Message: "x"}, deadlock: true},
+		{name: "mysql lock timeout", err: &mysqlError{Number: 1205, Message: "x"}, timeout: true},
+		{name: "deadlock message", err: errors.New("ERROR: deadlock detected (SQLSTATE 40P01)"), deadlock: true},
+		{name: "other", err: &mysqlError{Number: 1062, Message: "duplicate"}},
+		{name: "nil", err: nil},
+	}
+
+	for _, c := range cases {
+		if actual := Deadlock(c.err); actual != c.deadlock {
+			t.Errorf("%s: expected deadlock %v, got %v", c.name, c.deadlock, actual)
+		}
+		if actual := LockWaitTimeout(c.err); actual != c.timeout {
+			t.Errorf("%s: expected lock wait timeout %v, got %v", c.name, c.timeout, actual)
+		}
+		if expect := c.deadlock || c.timeout; Retryable(c.err) != expect {
+			t.Errorf("%s: expected retryable %v", c.name, expect)
+		}
+	}
+}
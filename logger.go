@@ -20,9 +20,18 @@ package gorm0log
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/rs/zerolog"
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
@@ -71,9 +80,158 @@ func WithTimeTracking(i logger.LogLevel) zerolog.Level {
 type Logger struct {
 	zerolog.Logger
 	Config
+
+	// names of context fields encoded by WithContext
+	bound map[string]bool
+	// created when first used, see states
+	state *loggerState
+}
+
+// loggerState keeps states of a Logger, which are built from [Config] once.
+type loggerState struct {
+	once    sync.Once
+	masks   map[string]func(string) string
+	late    lateWrites
+	repeats repeatCounter // for EscalateAfter
+	levels  atomic.Pointer[dumpLevels]
+}
+
+// dumpLevels are probed levels of audit and sql dumping messages. Probing is
+// affected by zerolog.GlobalLevel, so they are probed again once it changes.
+type dumpLevels struct {
+	global zerolog.Level
+	audit  zerolog.Level
+	dump   zerolog.Level
+}
+
+// dumpLevels returns probed levels of audit and sql dumping messages.
+func (l *Logger) dumpLevels() *dumpLevels {
+	s := l.states()
+	g := zerolog.GlobalLevel()
+	if ret := s.levels.Load(); ret != nil && ret.global == g {
+		return ret
+	}
+	ret := &dumpLevels{
+		global: g,
+		audit:  eventLevel(l.auditLevel),
+		dump:   eventLevel(l.dumpLevel),
+	}
+	s.levels.Store(ret)
+	return ret
+}
+
+// states returns states of l, creating them if needed. A copy of l shares
+// states with l if they are created before copying.
+func (l *Logger) states() *loggerState {
+	p := (*unsafe.Pointer)(unsafe.Pointer(&l.state))
+	if atomic.LoadPointer(p) == nil {
+		atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(&loggerState{}))
+	}
+	s := (*loggerState)(atomic.LoadPointer(p))
+	s.once.Do(func() {
+		s.masks = l.maskers()
+	})
+	return s
+}
+
+// MessageKind denotes type of messages logged by [Logger.Trace].
+type MessageKind int
+
+const (
+	KindNone  MessageKind = iota // no message
+	KindDump                     // sql dumping message
+	KindSlow                     // slow sql message
+	KindError                    // sql error message
+)
+
+func (k MessageKind) String() string {
+	switch k {
+	case KindDump:
+		return "dump"
+	case KindSlow:
+		return "slow"
+	case KindError:
+		return "error"
+	}
+	return "none"
+}
+
+// Tagged creates a [Logger] which adds a "component" field with value tag to
+// every message, so gorm messages can be told apart from other libraries sharing
+// same writer.
+//
+// The field is encoded into l once when creating the Logger, so it costs nothing
+// when logging, and works with any writer including [zerolog.ConsoleWriter].
+// Rewriting messages at writer level would parse every line instead. The field
+// is not deduplicated: if l already has a "component" field, both are logged.
+func Tagged(l zerolog.Logger, tag string, c Config) *Logger {
+	return &Logger{
+		Logger: l.With().Str("component", tag).Logger(),
+		Config: c,
+	}
+}
+
+// DiscardLogger creates a [Logger] which writes nothing, with its base logger
+// writing to [io.Discard] at Disabled level. Every message is skipped before
+// doing any real work, so it measures overhead of the logger itself, compared
+// to [logger.Discard] of gorm.
+//
+// Using [gorm.DB.Debug] lowers the level, and messages are built and then
+// discarded by the writer.
+func DiscardLogger(c Config) *Logger {
+	return &Logger{
+		Logger: zerolog.New(io.Discard).Level(zerolog.Disabled),
+		Config: c,
+	}
 }
 
+// ResetStats resets state accumulated for l, like at the start of a benchmark
+// window. It resets AutoEscalate and LatencyTracker in [Config] (or the
+// process-wide tracker if AdaptiveSlow is set without LatencyTracker), state of
+// EscalateAfter kept by l, and process-wide state of LogStmtCache, which is
+// shared by every logger. Trackers set in OnQuery, like [TableCounter], have to
+// be reset by yourself.
+//
+// It is safe to call concurrently with logging, queries running at the time
+// might be recorded either before or after resetting.
+func (l *Logger) ResetStats() {
+	if l.AutoEscalate != nil {
+		l.AutoEscalate.Reset()
+	}
+	if t := l.LatencyTracker; t != nil {
+		t.Reset()
+	} else if l.AdaptiveSlow {
+		defaultTracker.Reset()
+	}
+	resetStmtSeen()
+	l.states().repeats.reset()
+}
+
+// interfaces of gorm implemented by Logger
+var (
+	_ logger.Interface  = (*Logger)(nil)
+	_ gorm.ParamsFilter = (*Logger)(nil)
+	_ gorm.Plugin       = (*Logger)(nil)
+	_ logger.Interface  = (*fallbackLogger)(nil)
+	_ gorm.ParamsFilter = (*fallbackLogger)(nil)
+	_ gorm.Plugin       = (*fallbackLogger)(nil)
+)
+
+// Interface returns l as [logger.Interface], to be set to Logger of
+// [gorm.Config]:
+//
+//	db, err := gorm.Open(dialector, &gorm.Config{Logger: l.Interface()})
+//
+// Optional interfaces of gorm are detected by type assertion of the returned
+// value, so it is always l itself. Currently implemented optional interfaces
+// are [gorm.ParamsFilter], which hides parameters if ParameterizedQueries in
+// [Config] is set, and [gorm.Plugin], which has to be registered by
+// [gorm.DB.Use] explicitly.
+func (l *Logger) Interface() logger.Interface { return l }
+
 // LogMode implements [logger.Interface], to control which message is visible.
+// [logger.Info] mode maps to InfoMapsTo in [Config], which is Debug level by
+// default.
 func (l *Logger) LogMode(lv logger.LogLevel) logger.Interface {
 	var lvl zerolog.Level
 	switch {
@@ -84,64 +242,360 @@ func (l *Logger) LogMode(lv logger.LogLevel) logger.Interface {
 	case lv == logger.Warn:
 		lvl = zerolog.WarnLevel
 	default:
-		lvl = zerolog.DebugLevel
+		lvl = l.InfoMapsTo
 	}
 
 	return &Logger{
 		Logger: l.Logger.Level(lvl),
 		Config: l.Config,
+		bound:  l.bound,
+		state:  l.states(),
 	}
 }
 
+// WithFields creates a new [Logger] which logs fields in every message, merged
+// with Fields in [Config]. Fields set by [ContextWithFields] win if they have same
+// name.
+//
+// Unlike fields added by [zerolog.Context], which are encoded before anything
+// else and cannot be overridden, fields here are merged with context fields so
+// every field appears exactly once in a message.
+func (l *Logger) WithFields(fields map[string]any) *Logger {
+	ret := &Logger{
+		Logger: l.Logger,
+		Config: l.Config,
+		bound:  l.bound,
+		state:  l.states(),
+	}
+	ret.Fields = mergeFields(l.Fields, fields)
+	return ret
+}
+
+// WithContext creates a new [Logger] bound to ctx. Dialect, Fields and Customize
+// in [Config], and fields set by [ContextWithFields], are resolved with ctx once
+// and encoded into the underlying zerolog.Logger, so they are not computed again
+// for every message.
+//
+// Customizing functions of specific type of messages, like ErrorCustomize, are
+// still called with context of each query. The logger is intended to be created
+// once per request, and used with a gorm session of same context:
+//
+//	ctx := r.Context()
+//	tx := db.Session(&gorm.Session{Context: ctx, Logger: l.WithContext(ctx)})
+//
+// Values resolved by Customize are not updated, so do not use it with values
+// changing over time like [LogElapsed]. Fields set by [ContextWithFields] are
+// not logged again when querying with ctx, and bound ones win if the context of
+// a query has fields with same name.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	zc := l.Logger.With()
+	captureFields(l.custom(ctx), func(k string, val json.RawMessage) {
+		zc = zc.RawJSON(k, val)
+	})
+
+	bound := make(map[string]bool, len(l.bound))
+	for k := range l.bound {
+		bound[k] = true
+	}
+	for k := range fieldsFrom(ctx) {
+		bound[k] = true
+	}
+	ret := &Logger{
+		Logger: zc.Logger(),
+		Config: l.Config,
+		bound:  bound,
+		state:  l.states(),
+	}
+	ret.Dialect = ""
+	ret.Fields = nil
+	ret.Customize = nil
+	return ret
+}
+
+// EffectiveConfig returns the [Config] in effect, with default values filled in
+// for nil log level functions and empty json keys.
+func (l *Logger) EffectiveConfig() Config {
+	return l.Config.resolved()
+}
+
+// an error not matching any helper, used to probe log level of errors
+var errProbe = errors.New("gorm0log: probe")
+
+// WouldLog reports if specified kind of message is visible, with current log
+// level of the logger and the [Config]. For example, sql dumping message is
+// invisible if DumpLevel is [UseTrace] and the logger is at Debug level, which
+// is the case when you use [gorm.DB.Debug].
+//
+// KindError is tested against an error not matching any of helpers like
+// [CommonError], so the result might differ for specific errors if you have
+// customized ErrorLevel. KindSlow is always invisible if slow log is disabled.
+func (l *Logger) WouldLog(kind MessageKind) bool {
+	var fn func(zerolog.Logger) *zerolog.Event
+	switch kind {
+	case KindDump:
+		fn = l.dumpLevel
+	case KindSlow:
+		if l.SlowThreshold <= 0 && !l.AdaptiveSlow {
+			return false
+		}
+		fn = l.slowLevel
+	case KindError:
+		fn, _ = l.errLevelFn(errProbe, nil)
+	default:
+		return false
+	}
+
+	// probes level instead of creating event with l, as creating a disabled
+	// Fatal event exits the program
+	return levelVisible(l.Logger, eventLevel(fn))
+}
+
+// levelVisible reports if messages at lv are visible in l. It is decided by
+// zerolog, so a logger without writer, like zero value, logs nothing.
+func levelVisible(l zerolog.Logger, lv zerolog.Level) bool {
+	// WithLevel does not exit or panic for Fatal and Panic level
+	return lv != zerolog.Disabled && l.WithLevel(lv).Enabled()
+}
+
+// levelAllowed reports if lv passes level of l and global level. Unlike
+// levelVisible, no event is created, so samplers of l are not consulted.
+func levelAllowed(l zerolog.Logger, lv zerolog.Level) bool {
+	return lv != zerolog.Disabled && lv >= l.GetLevel() && lv >= zerolog.GlobalLevel()
+}
+
+// Validate reports configurations which make messages invisible unexpectedly,
+// like sql dumping messages which cannot be seen even with [gorm.DB.Debug]. It
+// returns nil if nothing is found. You might call it when setting up:
+//
+//	if err := l.Validate(); err != nil {
+//		log.Warn().Err(err).Msg("check your gorm logger")
+//	}
+//
+// Checks are based on log levels only, like [Logger.WouldLog].
+func (l *Logger) Validate() error {
+	var errs []error
+	debug := l.InfoMapsTo
+	check := func(name string, fn func(zerolog.Logger) *zerolog.Event) {
+		// probing cannot see levels below global level, they are reported
+		// like ignored messages
+		global := zerolog.GlobalLevel()
+		lv := eventLevel(fn)
+		switch {
+		case lv == zerolog.Disabled && global > zerolog.TraceLevel:
+			errs = append(errs, fmt.Errorf(
+				"%s is below global level %s, or ignored by its log level",
+				name, global,
+			))
+		case lv == zerolog.Disabled:
+			errs = append(errs, fmt.Errorf("%s is ignored by its log level", name))
+		case lv < debug && lv < l.Logger.GetLevel():
+			errs = append(errs, fmt.Errorf(
+				"%s at %s level is invisible even in Info mode, which uses %s level",
+				name, lv, debug,
+			))
+		}
+	}
+
+	check("sql dumping message", l.dumpLevel)
+	if l.SlowThreshold > 0 || l.AdaptiveSlow {
+		check("slow sql message", l.slowLevel)
+	}
+	errFn, _ := l.errLevelFn(errProbe, nil)
+	check("sql error message", errFn)
+	return errors.Join(errs...)
+}
+
 // Info implements [logger.Interface], to show a message at Info level.
 func (l *Logger) Info(ctx context.Context, msg string, args ...any) {
-	l.Logger.Info().Func(l.custom(ctx)).Msgf(msg, args...)
+	l.message(UseInfo, ctx, msg, args)
 }
 
 // Warn implements [logger.Interface], to show a message at Warn level.
+//
+// Gorm does not report slow sql with it: slow sql warnings of gorm are logged by
+// Trace of its default logger, and SlowThreshold of [logger.Config] has no
+// effect once this logger is used. Set SlowThreshold in [Config] instead, which
+// logs slow sql with structured fields.
 func (l *Logger) Warn(ctx context.Context, msg string, args ...any) {
-	l.Logger.Warn().Func(l.custom(ctx)).Msgf(msg, args...)
+	l.message(UseWarn, ctx, msg, args)
 }
 
 // Error implements [logger.Interface], to show a message at Error level.
 func (l *Logger) Error(ctx context.Context, msg string, args ...any) {
-	l.Logger.Error().Func(l.custom(ctx)).Msgf(msg, args...)
+	l.message(UseError, ctx, msg, args)
+}
+
+// logs a message from gorm
+func (l *Logger) message(lv func(zerolog.Logger) *zerolog.Event, ctx context.Context, msg string, args []any) {
+	ev := lv(l.Logger)
+	if !ev.Enabled() {
+		return
+	}
+
+	ev.Func(l.custom(withLevel(ctx, lv)))
+	if !l.RawMessages {
+		ev.Msgf(msg, args...)
+		return
+	}
+
+	if len(args) > 0 {
+		ev.Interface("args", args)
+	}
+	ev.Msg(msg)
 }
 
 // Trace implements [logger.Ingerface]. It is called every query by Gorm, so we can
 // provide useful features like slow log or sql dump.
 func (l *Logger) Trace(ctx context.Context, begin time.Time, f func() (string, int64), err error) {
-	dur := time.Since(begin)
+	l.trace(ctx, begin, f, err)
+}
+
+// trace logs messages of a query, and reports if any of them is visible
+func (l *Logger) trace(ctx context.Context, begin time.Time, f func() (string, int64), err error) bool {
+	l.reportLateWrites()
+	now := l.now()
+	dur := now.Sub(begin)
+	f = once(f)
+	slow, threshold := l.isSlow(ctx, dur, f)
+	addSummary(ctx, dur, slow, err)
+	if err != nil && l.AutoEscalate != nil {
+		l.AutoEscalate.fail(now)
+	}
+	if l.OnQuery != nil {
+		sql, rows := f()
+		l.OnQuery(ctx, QueryInfo{SQL: sql, Duration: dur, Rows: rows, Err: err})
+	}
+	common := l.logQuery(ctx, begin, dur, f, err)
+	st := stateFrom(ctx)
+	logged := false
 
 	if err != nil {
-		ev := l.errLevel(err, l.Logger)
-		ev.Func(l.custom(ctx)).Func(l.logErr(err, f)).Msg("a sql error occurred")
+		lv, msg := l.errLevelFn(err, f)
+		lv = l.firstError(lv, err, f, now)
+		ev := lv(l.Logger)
+		logged = ev.Enabled()
+		lctx := withLevel(ctx, lv)
+		l.tagKind(ev, KindError).Func(l.ordered(
+			l.custom(lctx),
+			l.customizeBy(lctx, l.ErrorCustomize),
+			l.logErr(err, dur, f, st),
+			common,
+		)).Msg(msg)
 
-		if ev.Enabled() {
+		if logged && !(l.SlowOnError && slow) {
 			// do not log other messages
-			return
+			return true
+		}
+	}
+
+	// events are created only when sending, as abandoned events still consume
+	// samplers, and are reported as late writes in StrictEvents mode
+	if l.WarnMissingLimit && err == nil && levelAllowed(l.Logger, zerolog.WarnLevel) {
+		if sql, _ := f(); missingLimit(sql) {
+			ev := UseWarn(l.Logger)
+			logged = logged || ev.Enabled()
+			l.tagKind(ev, KindSlow).Func(l.ordered(
+				l.custom(withLevel(ctx, UseWarn)),
+				l.logSlow(dur, f, st),
+				common,
+			)).Msg("sql query has no limit")
+		}
+	}
+
+	if l.EmptyReadLevel != nil && err == nil && levelAllowed(l.Logger, eventLevel(l.EmptyReadLevel)) {
+		if sql, rows := f(); rows == 0 && operation(sql) == "SELECT" {
+			ev := l.EmptyReadLevel(l.Logger)
+			logged = logged || ev.Enabled()
+			l.tagKind(ev, KindSlow).Func(l.ordered(
+				l.custom(withLevel(ctx, l.EmptyReadLevel)),
+				l.logSlow(dur, f, st),
+				common,
+			)).
+				Bool("empty_result", true).
+				Msg("sql query returns no rows")
 		}
 	}
 
-	if l.SlowThreshold > 0 && dur >= l.SlowThreshold {
+	if slow {
 		// slow log
-		l.slowLevel(l.Logger).
-			Func(l.custom(ctx)).
-			Func(l.logSlow(dur, f)).
-			Msg("sql query time exceeds threshold")
-		return
+		ev := l.slowLevel(l.Logger)
+		logged = logged || ev.Enabled()
+		lctx := withLevel(ctx, l.slowLevel)
+		l.tagKind(ev, KindSlow).Func(l.ordered(
+			l.custom(lctx),
+			l.customizeBy(lctx, l.SlowCustomize),
+			l.logSlow(dur, f, st),
+			l.logThreshold(threshold),
+			l.logCost(ctx, f),
+			common,
+		)).Msg("sql query time exceeds threshold")
+		return logged
+	}
+
+	if l.nearDeadline(ctx, begin, dur) {
+		ev := l.deadlineLevel(l.Logger)
+		logged = logged || ev.Enabled()
+		lctx := withLevel(ctx, l.deadlineLevel)
+		l.tagKind(ev, KindSlow).Func(l.ordered(
+			l.custom(lctx),
+			l.customizeBy(lctx, l.SlowCustomize),
+			l.logSlow(dur, f, st),
+			common,
+		)).Msg("sql query consumes most of time budget")
+		return logged
 	}
 
-	l.dumpLevel(l.Logger).
-		Func(l.custom(ctx)).
-		Func(l.logDump(dur, f)).
-		Msg("dump sql")
+	base := l.Logger
+	verbose := sampled(ctx) || (l.AutoEscalate != nil && l.AutoEscalate.escalated(now))
+	if verbose && base.GetLevel() != zerolog.Disabled {
+		base = base.Level(zerolog.TraceLevel)
+	}
+	audit := l.audit(base, f)
+	lv := l.dumpLevel
+	if audit {
+		lv = l.auditLevel
+	}
+	ev := lv(base)
+	if !verbose && !audit && ev.Enabled() && l.sampledOut(f) {
+		return logged
+	}
+	if l.DumpWhen != nil && !audit && ev.Enabled() && !l.DumpWhen(ctx) {
+		return logged
+	}
+	msg := "dump sql"
+	if (l.SkipEmptySQL || l.SkipMigration || len(l.OperationMessages) > 0) && ev.Enabled() {
+		sql, _ := f()
+		if l.SkipEmptySQL && strings.TrimSpace(sql) == "" {
+			return logged
+		}
+		if l.SkipMigration && isMigration(sql) {
+			return logged
+		}
+		msg = l.dumpMsg(sql)
+	}
+	logged = logged || ev.Enabled()
+	lctx := withLevel(ctx, lv)
+	l.tagKind(ev, KindDump).Func(l.ordered(
+		l.custom(lctx),
+		l.customizeBy(lctx, l.DumpCustomize),
+		l.logDump(dur, f, audit, st),
+		common,
+	)).Msg(msg)
+	return logged
 }
 
 // ParamsFilter implements [gorm.ParamsFilter] to check if parameters should be shown.
 func (l *Logger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
-	if l.ParameterizedQueries {
+	st := stateFrom(ctx)
+	if st != nil && l.WarnParamMismatch {
+		st.checked = true
+		st.placeholders, st.params = placeholders(sql), len(params)
+	}
+	if !l.paramsShown() {
 		return sql, nil
 	}
+	if st != nil && l.LogBothSQL && len(params) > 0 {
+		st.template = sql
+	}
 	return sql, params
 }
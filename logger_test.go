@@ -0,0 +1,1326 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// creates a logger writes json to returned buffer
+func bufLogger(c Config) (*Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	return &Logger{
+		Logger: zerolog.New(buf).Level(zerolog.TraceLevel),
+		Config: c,
+	}, buf
+}
+
+// parses every line in buf
+func parseLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var ret []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("cannot parse log %s: %v", line, err)
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
+
+func TestFieldsPrecedence(t *testing.T) {
+	l, buf := bufLogger(Config{})
+	l = l.WithFields(map[string]any{"app": "static", "env": "test"})
+	ctx := ContextWithFields(context.Background(), map[string]any{"app": "ctx"})
+	ctx = ContextWithFields(ctx, map[string]any{"req": "1"})
+
+	l.Info(ctx, "info")
+	l.Trace(ctx, time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if x := strings.Count(line, `"app":`); x != 1 {
+			t.Errorf("expected app to be logged once, got %d: %s", x, line)
+		}
+	}
+	for _, m := range parseLines(t, buf) {
+		if m["app"] != "ctx" {
+			t.Errorf("expected context field to win, got %v", m["app"])
+		}
+		if m["env"] != "test" || m["req"] != "1" {
+			t.Errorf("missing fields: %v", m)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		name   string
+		c      Config
+		val    string
+		n      int
+		expect string
+	}{
+		{name: "short", val: "abc", n: 3, expect: "abc"},
+		{name: "head", val: "abcdef", n: 3, expect: "abc…"},
+		{name: "tail", c: Config{TruncateKeepTail: true}, val: "abcdef", n: 3, expect: "…def"},
+		{name: "marker", c: Config{TruncateMarker: "[cut]"}, val: "abcdef", n: 3, expect: "abc[cut]"},
+		{name: "rune", val: "a中文", n: 2, expect: "a…"},
+		{name: "rune tail", c: Config{TruncateKeepTail: true}, val: "中文a", n: 2, expect: "…a"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := c.c.truncate(c.val, c.n); actual != c.expect {
+				t.Errorf("expected %s, got %s", c.expect, actual)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	err := errors.New("test")
+	cases := []struct {
+		name  string
+		c     Config
+		err   error
+		dur   time.Duration
+		kind  MessageKind
+		level zerolog.Level
+	}{
+		{name: "dump", kind: KindDump, level: zerolog.DebugLevel},
+		{name: "ignored", c: Config{DumpLevel: Ignore}, kind: KindNone, level: zerolog.Disabled},
+		{name: "error", err: err, kind: KindError, level: zerolog.ErrorLevel},
+		{name: "fatal", c: Config{ErrorLevel: LogErrorAt(UseFatal, ConnectionError)}, err: io.EOF, kind: KindError, level: zerolog.FatalLevel},
+		{name: "common", c: Config{ErrorLevel: DebugCommonErr}, err: gorm.ErrRecordNotFound, kind: KindError, level: zerolog.DebugLevel},
+		{name: "ignored error", c: Config{ErrorLevel: IgnoreCommonErr, DumpLevel: UseTrace}, err: gorm.ErrRecordNotFound, kind: KindError, level: zerolog.TraceLevel},
+		{name: "fast", c: Config{SlowThreshold: time.Second}, dur: time.Millisecond, kind: KindDump, level: zerolog.DebugLevel},
+		{name: "slow", c: Config{SlowThreshold: time.Second}, dur: time.Second, kind: KindSlow, level: zerolog.WarnLevel},
+		{name: "slow error", c: Config{SlowThreshold: time.Second}, err: err, dur: time.Second, kind: KindError, level: zerolog.ErrorLevel},
+		{name: "ignored slow", c: Config{SlowThreshold: time.Second, SlowLevel: Ignore}, dur: time.Second, kind: KindNone, level: zerolog.Disabled},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kind, lv := c.c.Decide(c.err, c.dur)
+			if kind != c.kind || lv != c.level {
+				t.Errorf("expected %s at %s, got %s at %s", c.kind, c.level, kind, lv)
+			}
+		})
+	}
+}
+
+// config enabling every feature which needs sql
+func sqlHungryConfig() Config {
+	return Config{
+		SlowThreshold:     time.Nanosecond,
+		SlowOnError:       true,
+		ErrorLevelByOp:    map[string]func(error, zerolog.Logger) *zerolog.Event{"SELECT": DebugCommonErr},
+		OperationMessages: map[string]string{"SELECT": "query"},
+		RowsKeyByOp:       map[string]string{"SELECT": "rows"},
+		AuditWrites:       true,
+		WarnMissingLimit:  true,
+	}
+}
+
+func TestTraceBuildsSQLOnce(t *testing.T) {
+	for _, err := range []error{nil, errors.New("test")} {
+		l, _ := bufLogger(sqlHungryConfig())
+		cnt := 0
+		l.Trace(context.Background(), time.Now().Add(-time.Second), func() (string, int64) {
+			cnt++
+			return "SELECT * FROM users", 1
+		}, err)
+		if cnt != 1 {
+			t.Errorf("err = %v: expected sql to be built once, got %d", err, cnt)
+		}
+	}
+}
+
+func BenchmarkTraceBuildsSQL(b *testing.B) {
+	l := &Logger{
+		Logger: zerolog.New(io.Discard).Level(zerolog.TraceLevel),
+		Config: sqlHungryConfig(),
+	}
+	ctx, err := context.Background(), errors.New("test")
+	begin := time.Now().Add(-time.Second)
+	cnt := 0
+	f := func() (string, int64) {
+		cnt++
+		return "SELECT * FROM users", 1
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Trace(ctx, begin, f, err)
+	}
+	b.ReportMetric(float64(cnt)/float64(b.N), "builds/op")
+}
+
+func TestRawMessages(t *testing.T) {
+	l, buf := bufLogger(Config{RawMessages: true})
+	l.Info(context.Background(), "100% done")
+	l.Warn(context.Background(), "table %s", "users")
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if x := lines[0]["message"]; x != "100% done" {
+		t.Errorf("unexpected message: %v", x)
+	}
+	if _, ok := lines[0]["args"]; ok {
+		t.Errorf("unexpected args: %v", lines[0])
+	}
+	if x := lines[1]["message"]; x != "table %s" {
+		t.Errorf("unexpected message: %v", x)
+	}
+	if args, _ := lines[1]["args"].([]any); len(args) != 1 || args[0] != "users" {
+		t.Errorf("unexpected args: %v", lines[1]["args"])
+	}
+}
+
+func benchmarkTrace(b *testing.B, l logger.Interface) {
+	ctx, begin := context.Background(), time.Now().Add(-time.Second)
+	f := func() (string, int64) { return "SELECT * FROM users", 1 }
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Trace(ctx, begin, f, nil)
+	}
+}
+
+func BenchmarkDiscardLogger(b *testing.B) {
+	benchmarkTrace(b, DiscardLogger(sqlHungryConfig()))
+}
+
+func BenchmarkGormDiscard(b *testing.B) {
+	benchmarkTrace(b, logger.Discard)
+}
+
+func TestReservedKeyPolicy(t *testing.T) {
+	customize := func(_ context.Context, ev *zerolog.Event) {
+		ev.Str("sql", "custom").Int("x", 1)
+	}
+	f := func() (string, int64) { return "SELECT 1", -1 }
+
+	cases := []struct {
+		policy KeyPolicy
+		expect string
+	}{
+		{AllowReservedKeys, `{"level":"debug","sql":"custom","x":1,"sql":"SELECT 1","message":"dump sql"}`},
+		{WarnReservedKeys, `{"level":"debug","x":1,"reserved_key_conflicts":["sql"],"sql":"SELECT 1","message":"dump sql"}`},
+		{PrefixReservedKeys, `{"level":"debug","custom_sql":"custom","x":1,"sql":"SELECT 1","message":"dump sql"}`},
+	}
+	for _, c := range cases {
+		l, buf := bufLogger(Config{Customize: customize, ReservedKeyPolicy: c.policy})
+		l.Trace(context.Background(), time.Now(), f, nil)
+		if actual := strings.TrimSpace(buf.String()); actual != c.expect {
+			t.Errorf("policy %d: expected %s, got %s", c.policy, c.expect, actual)
+		}
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	cnt := 0
+	l, buf := bufLogger(Config{
+		Fields: map[string]any{"app": "test"},
+		Customize: func(_ context.Context, ev *zerolog.Event) {
+			cnt++
+			ev.Int("cnt", cnt)
+		},
+	})
+	ctx := ContextWithFields(context.Background(), map[string]any{"req": "1"})
+	bound := l.WithContext(ctx)
+
+	f := func() (string, int64) { return "SELECT 1", -1 }
+	bound.Trace(ctx, time.Now(), f, nil)
+	bound.Trace(ctx, time.Now(), f, nil)
+
+	if n := strings.Count(buf.String(), `"req"`); n != 2 {
+		t.Errorf("expected req once per line, got %d: %s", n, buf.String())
+	}
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if line["app"] != "test" || line["req"] != "1" || line["cnt"] != float64(1) {
+			t.Errorf("unexpected fields: %v", line)
+		}
+	}
+	if cnt != 1 {
+		t.Errorf("expected Customize to be called once, got %d", cnt)
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	buckets := []time.Duration{10 * time.Millisecond, 1500 * time.Millisecond}
+	cases := []struct {
+		labels []string
+		dur    time.Duration
+		expect string
+	}{
+		{dur: time.Millisecond, expect: "le_10ms"},
+		{dur: 10 * time.Millisecond, expect: "le_10ms"},
+		{dur: time.Second, expect: "le_1_5s"},
+		{dur: time.Minute, expect: "le_inf"},
+		{labels: []string{"fast"}, dur: time.Millisecond, expect: "fast"},
+		{labels: []string{"fast"}, dur: time.Second, expect: "le_1_5s"},
+		{labels: []string{"fast", "ok", "slow"}, dur: time.Minute, expect: "slow"},
+	}
+
+	for _, c := range cases {
+		cfg := Config{DurationBuckets: buckets, LatencyBucketLabels: c.labels}
+		if actual := cfg.latencyBucket(c.dur); actual != c.expect {
+			t.Errorf("%v %v: expected %s, got %s", c.labels, c.dur, c.expect, actual)
+		}
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	l, buf := bufLogger(Config{Customize: LogAccumulated})
+	ctx, acc := ContextWithAccumulator(context.Background())
+	f := func() (string, int64) { return "SELECT 1", -1 }
+
+	l.Trace(ctx, time.Now(), f, nil)
+	acc.Set("cache_hit", false)
+	l.Trace(ctx, time.Now(), f, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if _, ok := lines[0]["cache_hit"]; ok {
+		t.Errorf("unexpected field: %v", lines[0])
+	}
+	if x, ok := lines[1]["cache_hit"]; !ok || x != false {
+		t.Errorf("expected accumulated field: %v", lines[1])
+	}
+}
+
+func TestLineLimiter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &Logger{Logger: zerolog.New(LineLimiter(buf, 100, Config{})).Level(zerolog.TraceLevel)}
+	long := "SELECT * FROM users WHERE name = '" + strings.Repeat("x", 200) + "'"
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return long, -1 }, nil)
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", -1 }, nil)
+
+	out := buf.String()
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), out)
+	}
+	first := strings.SplitN(out, "\n", 2)[0]
+	if len(first)+1 > 100 {
+		t.Errorf("line too long (%d bytes): %s", len(first)+1, first)
+	}
+	if sql, _ := lines[0]["sql"].(string); lines[0]["truncated"] != true || !strings.HasPrefix(sql, "SELECT * FROM users") {
+		t.Errorf("unexpected trimmed line: %v", lines[0])
+	}
+	if _, ok := lines[1]["truncated"]; ok || lines[1]["sql"] != "SELECT 1" {
+		t.Errorf("unexpected short line: %v", lines[1])
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	l, buf := bufLogger(Config{
+		DumpSampleRate: 1e-12,
+		SampleRateByOp: map[string]float64{"INSERT": 1},
+	})
+	for _, sql := range []string{"SELECT 1", "INSERT INTO x VALUES (1)", "UPDATE x SET a = 1"} {
+		sql := sql
+		for i := 0; i < 10; i++ {
+			l.Trace(context.Background(), time.Now(), func() (string, int64) { return sql, 1 }, nil)
+		}
+	}
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 0 }, errors.New("test"))
+
+	lines := parseLines(t, buf)
+	if len(lines) != 11 {
+		t.Fatalf("expected 11 lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, line := range lines[:10] {
+		if line["sql"] != "INSERT INTO x VALUES (1)" {
+			t.Errorf("unexpected message: %v", line)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		c    Config
+		ok   bool
+	}{
+		{name: "default", c: Config{}, ok: true},
+		{name: "trace dump", c: Config{DumpLevel: UseTrace}, ok: false},
+		{name: "trace dump in trace mode", c: Config{DumpLevel: UseTrace, InfoMapsTo: zerolog.TraceLevel}, ok: true},
+		{name: "ignored dump", c: Config{DumpLevel: Ignore}, ok: false},
+		{name: "ignored slow", c: Config{SlowThreshold: time.Second, SlowLevel: Ignore}, ok: false},
+		{name: "ignored slow disabled", c: Config{SlowLevel: Ignore}, ok: true},
+	}
+
+	for _, c := range cases {
+		l := &Logger{Logger: zerolog.New(io.Discard).Level(zerolog.WarnLevel), Config: c.c}
+		if err := l.Validate(); (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result: %v", c.name, err)
+		}
+	}
+}
+
+func TestValidateGlobalLevel(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	l := &Logger{Logger: zerolog.New(io.Discard), Config: Config{}}
+	err := l.Validate()
+	if err == nil || !strings.Contains(err.Error(), "sql dumping message is below global level info") {
+		t.Errorf("unexpected result: %v", err)
+	}
+}
+
+func TestNow(t *testing.T) {
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, buf := bufLogger(Config{
+		SlowThreshold: time.Second,
+		Now:           func() time.Time { return begin.Add(1500 * time.Millisecond) },
+	})
+	l.Trace(context.Background(), begin, func() (string, int64) { return "SELECT 1", -1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["message"] != "sql query time exceeds threshold" || lines[0]["duration"] != float64(1500) {
+		t.Errorf("unexpected message: %v", lines[0])
+	}
+}
+
+func TestErrorMarshal(t *testing.T) {
+	l, buf := bufLogger(Config{
+		ErrorMarshal: func(err error) any {
+			return strings.ReplaceAll(err.Error(), "secret", "***")
+		},
+		ErrorFields: map[string]string{"team": "db"},
+	})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", -1 }, errors.New("bad secret"))
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["error"] != "bad ***" || lines[0]["team"] != "db" {
+		t.Errorf("unexpected message: %v", lines[0])
+	}
+}
+
+func TestEscalateAfter(t *testing.T) {
+	now := time.Now()
+	l, buf := bufLogger(Config{
+		EscalateAfter: 2,
+		Now:           func() time.Time { return now },
+	})
+	for i := 0; i < 3; i++ {
+		id := strconv.Itoa(i)
+		l.Trace(context.Background(), now, func() (string, int64) {
+			return "SELECT * FROM escalate WHERE id = " + id, -1
+		}, errors.New("failed"))
+	}
+	// new window
+	now = now.Add(time.Minute)
+	l.Trace(context.Background(), now, func() (string, int64) {
+		return "SELECT * FROM escalate WHERE id = 3", -1
+	}, errors.New("failed"))
+
+	lines := parseLines(t, buf)
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %s", len(lines), buf.String())
+	}
+	expected := []string{"warn", "warn", "error", "warn"}
+	for i, lv := range expected {
+		if lines[i]["level"] != lv {
+			t.Errorf("line %d: expected %s, got %v", i, lv, lines[i]["level"])
+		}
+	}
+
+	// errors are counted for each logger
+	other, buf := bufLogger(l.Config)
+	other.Trace(context.Background(), now, func() (string, int64) {
+		return "SELECT * FROM escalate WHERE id = 4", -1
+	}, errors.New("failed"))
+	if line := parseLines(t, buf); len(line) != 1 || line[0]["level"] != "warn" {
+		t.Errorf("expected a warn message of other logger, got %v", line)
+	}
+
+	c := l.EffectiveConfig()
+	if c.EscalateWindow != time.Minute || eventLevel(c.FirstErrorLevel) != zerolog.WarnLevel {
+		t.Errorf("unexpected defaults: %v %v", c.EscalateWindow, eventLevel(c.FirstErrorLevel))
+	}
+}
+
+func TestStrictEvents(t *testing.T) {
+	var kept *zerolog.Event
+	l, buf := bufLogger(Config{
+		StrictEvents: true,
+		Customize: func(_ context.Context, ev *zerolog.Event) {
+			ev.Str("user", "a")
+			kept = ev
+		},
+	})
+	derived := l.LogMode(logger.Info).(*Logger)
+	other := &Logger{Logger: l.Logger, Config: Config{StrictEvents: true}}
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+	// events might not be watched, like in race mode
+	for i := 0; i < 10 && !strings.Contains(buf.String(), `"events"`); i++ {
+		buf.Reset()
+		derived.Trace(context.Background(), time.Now(), sql, nil)
+		kept.Str("late", "x")
+		other.Trace(context.Background(), time.Now(), sql, nil)
+		l.Trace(context.Background(), time.Now(), sql, nil)
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %s", len(lines), buf.String())
+	}
+	lines = append(lines[:1], lines[2:]...)
+	if lines[0]["user"] != "a" {
+		t.Errorf("expected field of customize, got %v", lines[0])
+	}
+	if lines[1]["level"] != "error" || lines[1]["events"] != float64(1) {
+		t.Errorf("expected diagnostic message, got %v", lines[1])
+	}
+	if _, ok := lines[2]["late"]; ok {
+		t.Errorf("unexpected late write: %v", lines[2])
+	}
+}
+
+func TestLogParamsShown(t *testing.T) {
+	for _, hide := range []bool{false, true} {
+		l, buf := bufLogger(Config{LogParamsShown: true, ParameterizedQueries: hide})
+		l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+		lines := parseLines(t, buf)
+		if len(lines) != 1 {
+			t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+		}
+		if lines[0]["params_shown"] != !hide {
+			t.Errorf("expected params_shown %v, got %v", !hide, lines[0]["params_shown"])
+		}
+	}
+}
+
+func TestEmptyReadLevel(t *testing.T) {
+	l, buf := bufLogger(Config{EmptyReadLevel: UseInfo, DumpLevel: Ignore})
+	for _, c := range []struct {
+		sql  string
+		rows int64
+	}{
+		{"SELECT * FROM users WHERE id = 1", 0},
+		{"SELECT * FROM users WHERE id = 2", 1},
+		{"UPDATE users SET name = 'a'", 0},
+	} {
+		l.Trace(context.Background(), time.Now(), func() (string, int64) { return c.sql, c.rows }, nil)
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["level"] != "info" || lines[0]["empty_result"] != true {
+		t.Errorf("unexpected message: %v", lines[0])
+	}
+}
+
+// countSampler counts events created by a logger
+type countSampler struct{ n int }
+
+func (s *countSampler) Sample(zerolog.Level) bool {
+	s.n++
+	return true
+}
+
+func TestUnsentEvents(t *testing.T) {
+	s := &countSampler{}
+	l := &Logger{
+		Logger: zerolog.New(io.Discard).Level(zerolog.DebugLevel).Sample(s),
+		Config: Config{WarnMissingLimit: true, EmptyReadLevel: UseInfo, DumpLevel: UseTrace},
+	}
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		return "SELECT * FROM users LIMIT 1", 1
+	}, nil)
+	if s.n != 0 {
+		t.Errorf("expected no event to be created, got %d", s.n)
+	}
+}
+
+func TestDurationByKind(t *testing.T) {
+	l, buf := bufLogger(Config{
+		SlowThreshold:       time.Second,
+		SlowWithoutDuration: true,
+		ErrorWithDuration:   true,
+		WarnMissingLimit:    true,
+	})
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+	l.Trace(context.Background(), time.Now(), sql, errors.New("x"))
+	l.Trace(context.Background(), time.Now().Add(-time.Minute), sql, nil)
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT * FROM users", 1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %s", len(lines), buf.String())
+	}
+	if _, ok := lines[2]["duration"]; ok || lines[2]["message"] != "sql query has no limit" {
+		t.Errorf("unexpected no limit message: %v", lines[2])
+	}
+	if _, ok := lines[0]["duration"]; !ok {
+		t.Errorf("expected duration in error message: %v", lines[0])
+	}
+	if _, ok := lines[1]["duration"]; ok {
+		t.Errorf("unexpected duration in slow message: %v", lines[1])
+	}
+}
+
+func TestFieldOrder(t *testing.T) {
+	l, buf := bufLogger(Config{
+		DumpWithDuration: true,
+		Dialect:          "pg",
+		FieldOrder:       []string{"sql", "duration"},
+	})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	line := buf.String()
+	idx := func(k string) int { return strings.Index(line, `"`+k+`":`) }
+	if !(idx("sql") < idx("duration") && idx("duration") < idx("dialect") && idx("dialect") < idx("affected_rows")) {
+		t.Errorf("unexpected order: %s", line)
+	}
+}
+
+func TestLogTableCount(t *testing.T) {
+	l, buf := bufLogger(Config{LogTableCount: true, LogTables: true})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		return "SELECT * FROM users u JOIN orders o ON o.user_id = u.id", 1
+	}, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["table_count"] != float64(2) || fmt.Sprint(lines[0]["tables"]) != "[users orders]" {
+		t.Errorf("unexpected message: %v", lines[0])
+	}
+}
+
+func TestContextWithoutSlowLog(t *testing.T) {
+	l, buf := bufLogger(Config{SlowThreshold: time.Second})
+	ctx := ContextWithoutSlowLog(context.Background())
+	l.Trace(ctx, time.Now().Add(-time.Minute), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["message"] != "dump sql" {
+		t.Errorf("expected sql dumping message, got %v", lines[0])
+	}
+}
+
+func TestEffectiveSlowThreshold(t *testing.T) {
+	ctx := context.Background()
+	c := Config{SlowThreshold: time.Second}
+	if th := c.EffectiveSlowThreshold(ctx, "SELECT", "users"); th != time.Second {
+		t.Errorf("expected 1s, got %v", th)
+	}
+	if th := c.EffectiveSlowThreshold(ContextWithoutSlowLog(ctx), "SELECT", "users"); th != 0 {
+		t.Errorf("expected 0 if disabled by context, got %v", th)
+	}
+	if th := (Config{}).EffectiveSlowThreshold(ctx, "SELECT", "users"); th != 0 {
+		t.Errorf("expected 0 if not set, got %v", th)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	b := Builder(zerolog.New(buf).Level(zerolog.TraceLevel)).
+		SlowThreshold(time.Second).
+		DumpAt(UseTrace).
+		ErrorsAt(DebugCommonErr).
+		Configure(func(c *Config) { c.Dialect = "pg" })
+	l := b.Build()
+	b.SlowThreshold(time.Minute)
+
+	if l.SlowThreshold != time.Second || l.Dialect != "pg" {
+		t.Errorf("unexpected config: %+v", l.Config)
+	}
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, gorm.ErrRecordNotFound)
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["level"] != "debug" || lines[1]["level"] != "trace" {
+		t.Errorf("unexpected levels: %v, %v", lines[0]["level"], lines[1]["level"])
+	}
+}
+
+func TestWouldLog(t *testing.T) {
+	var zero Logger
+	if zero.WouldLog(KindDump) || zero.WouldLog(KindError) {
+		t.Error("zero value logger logs nothing")
+	}
+
+	l, _ := bufLogger(Config{DumpLevel: UseTrace, ErrorLevel: func(_ error, l zerolog.Logger) *zerolog.Event {
+		return UseFatal(l)
+	}})
+	l.Logger = l.Logger.Level(zerolog.DebugLevel)
+	if l.WouldLog(KindDump) || !l.WouldLog(KindError) || l.WouldLog(KindSlow) {
+		t.Error("unexpected visibility")
+	}
+
+	l.AdaptiveSlow = true
+	if !l.WouldLog(KindSlow) {
+		t.Error("expected slow message to be visible with AdaptiveSlow")
+	}
+	if p := l.EffectiveConfig().SlowPercentile; p != 0.95 {
+		t.Errorf("unexpected default SlowPercentile: %v", p)
+	}
+}
+
+func TestEventLevel(t *testing.T) {
+	cases := []struct {
+		fn     func(zerolog.Logger) *zerolog.Event
+		expect zerolog.Level
+	}{
+		{fn: UseTrace, expect: zerolog.TraceLevel},
+		{fn: UseWarn, expect: zerolog.WarnLevel},
+		{fn: UseFatal, expect: zerolog.FatalLevel},
+		{fn: func(l zerolog.Logger) *zerolog.Event { return l.Panic() }, expect: zerolog.PanicLevel},
+		{fn: Ignore, expect: zerolog.Disabled},
+	}
+	for _, c := range cases {
+		if actual := eventLevel(c.fn); actual != c.expect {
+			t.Errorf("expected %s, got %s", c.expect, actual)
+		}
+	}
+}
+
+func TestAuditWritesLazy(t *testing.T) {
+	l := &Logger{
+		Logger: zerolog.New(io.Discard).Level(zerolog.WarnLevel),
+		Config: Config{AuditWrites: true},
+	}
+	built := false
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		built = true
+		return "UPDATE users SET name = 'x'", 1
+	}, nil)
+	if built {
+		t.Error("sql is built though no message is visible")
+	}
+}
+
+func TestAuditWritesGlobalLevel(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	buf := &bytes.Buffer{}
+	l := &Logger{
+		Logger: zerolog.New(buf).Level(zerolog.TraceLevel),
+		Config: Config{AuditWrites: true, AuditLevel: UseDebug},
+	}
+	f := func() (string, int64) { return "UPDATE users SET name = 'x'", 1 }
+
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	l.Trace(context.Background(), time.Now(), f, nil)
+	if buf.Len() > 0 {
+		t.Fatalf("audit message is logged below global level: %s", buf.String())
+	}
+
+	// levels are probed again when global level changes
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	l.Trace(context.Background(), time.Now(), f, nil)
+	if !strings.Contains(buf.String(), `"audit":true`) {
+		t.Errorf("expected audit message, got %s", buf.String())
+	}
+}
+
+// traces a query lasting dur with a logger of c, and returns the only message
+func traceOnce(t *testing.T, ctx context.Context, c Config, dur time.Duration, sql string, rows int64, err error) map[string]any {
+	t.Helper()
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if c.Now == nil {
+		c.Now = func() time.Time { return begin.Add(dur) }
+	}
+	l, buf := bufLogger(c)
+	l.Trace(ctx, begin, func() (string, int64) { return sql, rows }, err)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	return lines[0]
+}
+
+func TestDurationSecondsKey(t *testing.T) {
+	c := Config{SlowThreshold: time.Second, DurationSecondsKey: "duration_s"}
+	m := traceOnce(t, context.Background(), c, 1500*time.Millisecond, "SELECT 1", 1, nil)
+	if m["duration_s"] != 1.5 || m["duration"] != float64(1500) {
+		t.Errorf("unexpected message: %v", m)
+	}
+
+	c.DurationSecondsKey = ""
+	m = traceOnce(t, context.Background(), c, 1500*time.Millisecond, "SELECT 1", 1, nil)
+	if _, ok := m["duration_s"]; ok {
+		t.Errorf("unexpected float seconds: %v", m)
+	}
+}
+
+func TestLogElapsed(t *testing.T) {
+	c := Config{Customize: LogElapsed("elapsed")}
+	ctx := ContextWithStart(context.Background(), time.Now().Add(-time.Minute))
+	m := traceOnce(t, ctx, c, 0, "SELECT 1", 1, nil)
+	if v, ok := m["elapsed"].(float64); !ok || v < float64(time.Minute/time.Millisecond) {
+		t.Errorf("expected elapsed time since start, got %v", m)
+	}
+
+	m = traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, nil)
+	if _, ok := m["elapsed"]; ok {
+		t.Errorf("unexpected elapsed time without start: %v", m)
+	}
+}
+
+func TestConnErrorLevel(t *testing.T) {
+	c := Config{ConnErrorLevel: UseWarn}
+	m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 0, fmt.Errorf("query: %w", io.EOF))
+	if m["level"] != "warn" || m["message"] != "a connection error occurred" {
+		t.Errorf("unexpected connection error message: %v", m)
+	}
+
+	m = traceOnce(t, context.Background(), c, 0, "SELECT 1", 0, errors.New("syntax error"))
+	if m["level"] != "error" || m["message"] != "a sql error occurred" {
+		t.Errorf("unexpected sql error message: %v", m)
+	}
+}
+
+func TestLogDeadline(t *testing.T) {
+	begin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithDeadline(context.Background(), begin.Add(time.Second))
+	defer cancel()
+
+	m := traceOnce(t, ctx, Config{LogDeadline: true}, 2*time.Second, "SELECT 1", 1, nil)
+	if m["time_to_deadline"] != float64(-1000) || m["deadline_exceeded"] != true {
+		t.Errorf("unexpected deadline info: %v", m)
+	}
+
+	m = traceOnce(t, ctx, Config{DeadlineRatio: 0.8}, 900*time.Millisecond, "SELECT 1", 1, nil)
+	if m["level"] != "warn" || m["message"] != "sql query consumes most of time budget" {
+		t.Errorf("expected message of time budget, got %v", m)
+	}
+	m = traceOnce(t, ctx, Config{DeadlineRatio: 0.8}, 100*time.Millisecond, "SELECT 1", 1, nil)
+	if m["message"] != "dump sql" {
+		t.Errorf("expected sql dumping message, got %v", m)
+	}
+}
+
+func TestDurationRound(t *testing.T) {
+	c := Config{SlowThreshold: time.Second, DurationRound: 100 * time.Millisecond}
+	m := traceOnce(t, context.Background(), c, 1234*time.Millisecond, "SELECT 1", 1, nil)
+	if m["duration"] != float64(1200) {
+		t.Errorf("expected rounded duration, got %v", m)
+	}
+}
+
+func TestContextWithSummary(t *testing.T) {
+	l, _ := bufLogger(Config{SlowThreshold: time.Second})
+	ctx, flush := ContextWithSummary(context.Background())
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+	l.Trace(ctx, time.Now(), sql, nil)
+	l.Trace(ctx, time.Now().Add(-time.Minute), sql, nil)
+	l.Trace(ctx, time.Now(), sql, errors.New("x"))
+	l.Trace(context.Background(), time.Now(), sql, nil)
+
+	buf := &bytes.Buffer{}
+	out := zerolog.New(buf)
+	flush(out.Info())
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	m := lines[0]
+	if m["queries"] != float64(3) || m["slow_queries"] != float64(1) || m["failed_queries"] != float64(1) {
+		t.Errorf("unexpected summary: %v", m)
+	}
+	if v, _ := m["db_time"].(float64); v < float64(time.Minute/time.Millisecond) {
+		t.Errorf("unexpected db time: %v", m)
+	}
+}
+
+func TestLogReadOnly(t *testing.T) {
+	c := Config{Customize: LogReadOnly("read_only")}
+	m := traceOnce(t, ContextWithReadOnly(context.Background()), c, 0, "SELECT 1", 1, nil)
+	if m["read_only"] != true {
+		t.Errorf("expected read only flag, got %v", m)
+	}
+
+	m = traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, nil)
+	if _, ok := m["read_only"]; ok {
+		t.Errorf("unexpected read only flag: %v", m)
+	}
+}
+
+func TestOperationMessages(t *testing.T) {
+	c := Config{OperationMessages: map[string]string{"SELECT": "query", "INSERT": "create"}}
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{sql: "SELECT * FROM users", expect: "query"},
+		{sql: "insert into users (name) values ('x')", expect: "create"},
+		{sql: "DELETE FROM users", expect: "dump sql"},
+	}
+
+	for _, x := range cases {
+		if m := traceOnce(t, context.Background(), c, 0, x.sql, 1, nil); m["message"] != x.expect {
+			t.Errorf("%s: expected %s, got %v", x.sql, x.expect, m["message"])
+		}
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	l := &Logger{Config: Config{SQL: "query", DumpLevel: UseTrace}}
+	c := l.EffectiveConfig()
+	if c.SQL != "query" || c.Duration != "duration" || c.AffectedRows != "affected_rows" {
+		t.Errorf("unexpected keys: %q, %q, %q", c.SQL, c.Duration, c.AffectedRows)
+	}
+	if lv := eventLevel(c.DumpLevel); lv != zerolog.TraceLevel {
+		t.Errorf("expected dump level to be kept, got %s", lv)
+	}
+	if lv := eventLevel(c.SlowLevel); lv != zerolog.WarnLevel {
+		t.Errorf("expected default slow level, got %s", lv)
+	}
+	errLv := func(l zerolog.Logger) *zerolog.Event { return c.ErrorLevel(errProbe, l) }
+	if lv := eventLevel(errLv); lv != zerolog.ErrorLevel {
+		t.Errorf("expected default error level, got %s", lv)
+	}
+	if l.SQL != "query" || l.Duration != "" {
+		t.Error("config of the logger is changed")
+	}
+}
+
+func TestSlowOnError(t *testing.T) {
+	for _, both := range []bool{false, true} {
+		l, buf := bufLogger(Config{SlowThreshold: time.Second, SlowOnError: both})
+		l.Trace(context.Background(), time.Now().Add(-time.Minute), func() (string, int64) {
+			return "SELECT 1", 0
+		}, errors.New("x"))
+
+		var msgs []any
+		for _, m := range parseLines(t, buf) {
+			msgs = append(msgs, m["message"])
+		}
+		expect := []any{"a sql error occurred"}
+		if both {
+			expect = append(expect, "sql query time exceeds threshold")
+		}
+		if !reflect.DeepEqual(msgs, expect) {
+			t.Errorf("SlowOnError = %v: expected %v, got %v", both, expect, msgs)
+		}
+	}
+}
+
+func TestLogErrorChain(t *testing.T) {
+	err := fmt.Errorf("outer: %w", fmt.Errorf("inner: %w", io.EOF))
+	m := traceOnce(t, context.Background(), Config{LogErrorChain: true}, 0, "SELECT 1", 0, err)
+	expect := []any{"outer: inner: EOF", "inner: EOF", "EOF"}
+	if !reflect.DeepEqual(m["error_chain"], expect) {
+		t.Errorf("expected %v, got %v", expect, m["error_chain"])
+	}
+
+	// deep chains are cut
+	var deep error = io.EOF
+	for i := 0; i < 20; i++ {
+		deep = fmt.Errorf("%d: %w", i, deep)
+	}
+	if x := len(errorChain(deep)); x != maxErrorChain {
+		t.Errorf("expected %d layers, got %d", maxErrorChain, x)
+	}
+}
+
+func TestSQLOnProblemOnly(t *testing.T) {
+	c := Config{SlowThreshold: time.Second, SQLOnProblemOnly: true}
+	m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, nil)
+	if _, ok := m["sql"]; ok || m["affected_rows"] != float64(1) {
+		t.Errorf("expected dump without sql, got %v", m)
+	}
+
+	m = traceOnce(t, context.Background(), c, time.Minute, "SELECT 1", 1, nil)
+	if m["sql"] != "SELECT 1" {
+		t.Errorf("expected slow message with sql, got %v", m)
+	}
+	m = traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, errors.New("x"))
+	if m["sql"] != "SELECT 1" {
+		t.Errorf("expected error message with sql, got %v", m)
+	}
+}
+
+func TestRowsKeyByOp(t *testing.T) {
+	c := Config{AffectedRows: "rows", RowsKeyByOp: map[string]string{"SELECT": "rows_read"}}
+	m := traceOnce(t, context.Background(), c, 0, "SELECT * FROM users", 3, nil)
+	if m["rows_read"] != float64(3) {
+		t.Errorf("expected rows key of SELECT, got %v", m)
+	}
+	m = traceOnce(t, context.Background(), c, 0, "DELETE FROM users", 3, nil)
+	if m["rows"] != float64(3) {
+		t.Errorf("expected fallback rows key, got %v", m)
+	}
+}
+
+func TestTagged(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := Tagged(zerolog.New(buf).Level(zerolog.TraceLevel), "gorm", Config{})
+	l.Info(context.Background(), "info")
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, m := range lines {
+		if m["component"] != "gorm" {
+			t.Errorf("expected component field, got %v", m)
+		}
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	called := 0
+	c := Config{
+		SlowThreshold: time.Second,
+		CostFunc: func(_ context.Context, sql string) (float64, error) {
+			called++
+			if sql == "SELECT 2" {
+				return 0, errors.New("cannot explain")
+			}
+			return 12.5, nil
+		},
+	}
+	m := traceOnce(t, context.Background(), c, time.Minute, "SELECT 1", 1, nil)
+	if m["plan_cost"] != 12.5 {
+		t.Errorf("expected plan cost, got %v", m)
+	}
+	m = traceOnce(t, context.Background(), c, time.Minute, "SELECT 2", 1, nil)
+	if m["plan_cost_error"] != "cannot explain" {
+		t.Errorf("expected plan cost error, got %v", m)
+	}
+
+	called = 0
+	traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, nil)
+	if called != 0 {
+		t.Errorf("cost function is called for fast query")
+	}
+}
+
+func TestDialect(t *testing.T) {
+	l, buf := bufLogger(Config{Dialect: "postgres"})
+	l.Warn(context.Background(), "warn")
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, m := range lines {
+		if m["dialect"] != "postgres" {
+			t.Errorf("expected dialect, got %v", m)
+		}
+	}
+}
+
+func TestCustomizeByKind(t *testing.T) {
+	mark := func(kind string) func(context.Context, *zerolog.Event) {
+		return func(_ context.Context, ev *zerolog.Event) { ev.Str("kind", kind) }
+	}
+	c := Config{
+		SlowThreshold:  time.Second,
+		Customize:      func(_ context.Context, ev *zerolog.Event) { ev.Bool("common", true) },
+		ErrorCustomize: mark("error"),
+		SlowCustomize:  mark("slow"),
+		DumpCustomize:  mark("dump"),
+	}
+	cases := []struct {
+		dur    time.Duration
+		err    error
+		expect string
+	}{
+		{err: errors.New("x"), expect: "error"},
+		{dur: time.Minute, expect: "slow"},
+		{expect: "dump"},
+	}
+
+	for _, x := range cases {
+		m := traceOnce(t, context.Background(), c, x.dur, "SELECT 1", 1, x.err)
+		if m["kind"] != x.expect || m["common"] != true {
+			t.Errorf("expected %s message with common field, got %v", x.expect, m)
+		}
+	}
+}
+
+func TestGlobalSequence(t *testing.T) {
+	l, buf := bufLogger(Config{GlobalSequence: true, SequenceKey: "n"})
+	other, otherBuf := bufLogger(Config{GlobalSequence: true, SequenceKey: "n"})
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+	l.Trace(context.Background(), time.Now(), sql, nil)
+	other.Trace(context.Background(), time.Now(), sql, nil)
+	l.Trace(context.Background(), time.Now(), sql, nil)
+
+	lines := append(parseLines(t, buf), parseLines(t, otherBuf)...)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+	first, _ := lines[0]["n"].(float64)
+	// sequence is shared by loggers
+	if lines[2]["n"] != first+1 || lines[1]["n"] != first+2 {
+		t.Errorf("unexpected sequence: %v, %v, %v", lines[0]["n"], lines[2]["n"], lines[1]["n"])
+	}
+}
+
+func TestSkipEmptySQL(t *testing.T) {
+	l, buf := bufLogger(Config{SkipEmptySQL: true})
+	empty := func() (string, int64) { return "  ", 0 }
+	l.Trace(context.Background(), time.Now(), empty, nil)
+	if buf.Len() > 0 {
+		t.Errorf("unexpected dump of empty sql: %s", buf.String())
+	}
+
+	l.Trace(context.Background(), time.Now(), empty, errors.New("x"))
+	lines := parseLines(t, buf)
+	if len(lines) != 1 || lines[0]["message"] != "a sql error occurred" {
+		t.Errorf("expected error of empty sql to be logged, got %v", lines)
+	}
+}
+
+func TestLogLabels(t *testing.T) {
+	ctx := ContextWithLabels(context.Background(), map[string]string{"route": "/a", "version": "v1"})
+	ctx = ContextWithLabels(ctx, map[string]string{"route": "/b"})
+	m := traceOnce(t, ctx, Config{Customize: LogLabels}, 0, "SELECT 1", 1, nil)
+	if m["route"] != "/b" || m["version"] != "v1" {
+		t.Errorf("unexpected labels: %v", m)
+	}
+}
+
+func TestAffectedRowsAsString(t *testing.T) {
+	l, buf := bufLogger(Config{AffectedRowsAsString: true})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		return "DELETE FROM logs", 9007199254740993
+	}, nil)
+	if !strings.Contains(buf.String(), `"affected_rows":"9007199254740993"`) {
+		t.Errorf("expected affected rows as string, got %s", buf.String())
+	}
+}
+
+func TestErrorLevelByOp(t *testing.T) {
+	c := Config{
+		ErrorLevelByOp: map[string]func(error, zerolog.Logger) *zerolog.Event{
+			"SELECT": LogErrorAt(UseInfo, CommonError),
+		},
+		ExpectedError: CommonError,
+	}
+	cases := []struct {
+		sql   string
+		level string
+		msg   string
+	}{
+		{sql: "SELECT * FROM users", level: "info", msg: "a sql error occurred"},
+		{sql: "UPDATE users SET name = 'x'", level: "debug", msg: "an expected sql error occurred"},
+	}
+
+	for _, x := range cases {
+		m := traceOnce(t, context.Background(), c, 0, x.sql, 0, gorm.ErrRecordNotFound)
+		if m["level"] != x.level || m["message"] != x.msg {
+			t.Errorf("%s: expected %s at %s, got %v", x.sql, x.msg, x.level, m)
+		}
+	}
+}
+
+func TestContextWithSampled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &Logger{Logger: zerolog.New(buf).Level(zerolog.InfoLevel)}
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+	l.Trace(context.Background(), time.Now(), sql, nil)
+	l.Trace(ContextWithSampled(context.Background(), false), time.Now(), sql, nil)
+	if buf.Len() > 0 {
+		t.Fatalf("unexpected dump at info level: %s", buf.String())
+	}
+
+	l.Trace(ContextWithSampled(context.Background(), true), time.Now(), sql, nil)
+	lines := parseLines(t, buf)
+	if len(lines) != 1 || lines[0]["level"] != "debug" {
+		t.Errorf("expected dump of sampled query, got %v", lines)
+	}
+}
+
+func TestExpectedError(t *testing.T) {
+	c := Config{ExpectedError: CommonError, ExpectedLevel: UseInfo}
+	m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 0, fmt.Errorf("find: %w", gorm.ErrRecordNotFound))
+	if m["level"] != "info" || m["message"] != "an expected sql error occurred" {
+		t.Errorf("unexpected message of expected error: %v", m)
+	}
+
+	m = traceOnce(t, context.Background(), c, 0, "SELECT 1", 0, errors.New("x"))
+	if m["level"] != "error" || m["message"] != "a sql error occurred" {
+		t.Errorf("unexpected message of other error: %v", m)
+	}
+}
+
+func TestOmitZeroDuration(t *testing.T) {
+	c := Config{DumpWithDuration: true, OmitZeroDuration: true, DurationRound: 10 * time.Millisecond}
+	m := traceOnce(t, context.Background(), c, 3*time.Millisecond, "SELECT 1", 1, nil)
+	if _, ok := m["duration"]; ok {
+		t.Errorf("unexpected zero duration: %v", m)
+	}
+	m = traceOnce(t, context.Background(), c, 30*time.Millisecond, "SELECT 1", 1, nil)
+	if m["duration"] != float64(30) {
+		t.Errorf("expected duration, got %v", m)
+	}
+}
+
+func TestDurationSecondsPrecision(t *testing.T) {
+	c := Config{SlowThreshold: time.Second, DurationSecondsKey: "duration_s", DurationSecondsPrecision: 2}
+	m := traceOnce(t, context.Background(), c, 1234567*time.Microsecond, "SELECT 1", 1, nil)
+	if m["duration_s"] != 1.23 {
+		t.Errorf("expected rounded float seconds, got %v", m)
+	}
+}
+
+func TestOmitSQL(t *testing.T) {
+	c := Config{SlowThreshold: time.Second, OmitSQL: true, OperationMessages: map[string]string{"SELECT": "query"}}
+	cases := []struct {
+		dur time.Duration
+		err error
+	}{
+		{},
+		{dur: time.Minute},
+		{err: errors.New("x")},
+	}
+
+	for _, x := range cases {
+		m := traceOnce(t, context.Background(), c, x.dur, "SELECT 1", 1, x.err)
+		if _, ok := m["sql"]; ok || m["affected_rows"] != float64(1) {
+			t.Errorf("expected message without sql, got %v", m)
+		}
+	}
+	// sql is still used internally
+	if m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, nil); m["message"] != "query" {
+		t.Errorf("expected message of operation, got %v", m)
+	}
+}
+
+func TestLogReturnedRows(t *testing.T) {
+	c := Config{LogReturnedRows: true}
+	m := traceOnce(t, context.Background(), c, 0, `INSERT INTO "users" ("name") VALUES ('a'),('b') RETURNING "id"`, 2, nil)
+	if m["returned_rows"] != float64(2) || m["affected_rows"] != float64(2) {
+		t.Errorf("expected returned rows, got %v", m)
+	}
+	m = traceOnce(t, context.Background(), c, 0, `INSERT INTO "users" ("name") VALUES ('a')`, 1, nil)
+	if _, ok := m["returned_rows"]; ok {
+		t.Errorf("unexpected returned rows: %v", m)
+	}
+}
+
+func TestLogErrorType(t *testing.T) {
+	err := &pgError{Code: "23505", Message: "x"}
+	m := traceOnce(t, context.Background(), Config{LogErrorType: true}, 0, "SELECT 1", 0, err)
+	if m["error_type"] != "*gorm0log.pgError" {
+		t.Errorf("expected type of the error, got %v", m)
+	}
+	m = traceOnce(t, context.Background(), Config{LogErrorType: true, ErrorTypeKey: "type"}, 0, "SELECT 1", 0, io.EOF)
+	if m["type"] != "*errors.errorString" {
+		t.Errorf("expected type in custom key, got %v", m)
+	}
+}
+
+func TestOmitAffectedRows(t *testing.T) {
+	c := Config{OmitAffectedRows: true, AffectedRowsAsString: true, LogReturnedRows: true}
+	m := traceOnce(t, context.Background(), c, 0, `INSERT INTO "users" ("name") VALUES ('a') RETURNING "id"`, 1, nil)
+	for _, k := range []string{"affected_rows", "returned_rows"} {
+		if _, ok := m[k]; ok {
+			t.Errorf("unexpected %s: %v", k, m)
+		}
+	}
+}
+
+func TestStatusKey(t *testing.T) {
+	c := Config{StatusKey: "ok"}
+	if m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 1, nil); m["ok"] != true {
+		t.Errorf("expected succeeded query, got %v", m)
+	}
+	if m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 0, errors.New("x")); m["ok"] != false {
+		t.Errorf("expected failed query, got %v", m)
+	}
+}
+
+func TestErrorFields(t *testing.T) {
+	c := Config{SlowThreshold: time.Second, ErrorFields: map[string]string{"runbook": "https://example.com/db", "team": "db"}}
+	m := traceOnce(t, context.Background(), c, 0, "SELECT 1", 0, errors.New("x"))
+	if m["runbook"] != "https://example.com/db" || m["team"] != "db" {
+		t.Errorf("expected error fields, got %v", m)
+	}
+
+	for _, dur := range []time.Duration{0, time.Minute} {
+		m = traceOnce(t, context.Background(), c, dur, "SELECT 1", 1, nil)
+		if _, ok := m["team"]; ok {
+			t.Errorf("unexpected error fields in %s: %v", m["message"], m)
+		}
+	}
+}
+
+type debugKey struct{}
+
+func TestDumpWhen(t *testing.T) {
+	l, buf := bufLogger(Config{
+		SlowThreshold: time.Second,
+		DumpWhen: func(ctx context.Context) bool {
+			return ctx.Value(debugKey{}) != nil
+		},
+	})
+	sql := func() (string, int64) { return "SELECT 1", 1 }
+	l.Trace(context.Background(), time.Now(), sql, nil)
+	if buf.Len() > 0 {
+		t.Fatalf("unexpected dump: %s", buf.String())
+	}
+
+	l.Trace(context.WithValue(context.Background(), debugKey{}, true), time.Now(), sql, nil)
+	l.Trace(context.Background(), time.Now().Add(-time.Minute), sql, nil)
+	var msgs []any
+	for _, m := range parseLines(t, buf) {
+		msgs = append(msgs, m["message"])
+	}
+	if expect := []any{"dump sql", "sql query time exceeds threshold"}; !reflect.DeepEqual(msgs, expect) {
+		t.Errorf("expected %v, got %v", expect, msgs)
+	}
+}
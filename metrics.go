@@ -0,0 +1,89 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// QueryInfo describes a query traced by [Logger], see OnQuery in [Config].
+type QueryInfo struct {
+	SQL      string
+	Duration time.Duration
+	// Affected rows, -1 if not available.
+	Rows int64
+	Err  error
+}
+
+// OpTable is a pair of type of sql statement, like "SELECT", and its main table.
+type OpTable struct {
+	Op    string
+	Table string
+}
+
+// OtherTables is the table name of queries to tables exceeding limit of
+// [TableCounter].
+const OtherTables = "(other)"
+
+// TableCounter counts queries by type of sql statement and main table, which are
+// detected by simple heuristic. Use its OnQuery method as OnQuery in [Config].
+// Queries without table, like "SELECT 1", are counted with empty table name.
+//
+// It is safe for concurrent use.
+type TableCounter struct {
+	max    int
+	lock   sync.Mutex
+	tables map[string]bool
+	counts map[OpTable]int64
+}
+
+// NewTableCounter creates a [TableCounter] tracking at most maxTables distinct
+// tables to bound memory usage. Queries to other tables are counted as
+// [OtherTables]. 0 or less means no limit.
+func NewTableCounter(maxTables int) *TableCounter {
+	return &TableCounter{
+		max:    maxTables,
+		tables: map[string]bool{},
+		counts: map[OpTable]int64{},
+	}
+}
+
+// OnQuery counts a query, it can be used as OnQuery in [Config].
+func (c *TableCounter) OnQuery(_ context.Context, q QueryInfo) {
+	key := OpTable{Op: operation(q.SQL), Table: table(q.SQL)}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.tables[key.Table] {
+		if c.max > 0 && len(c.tables) >= c.max {
+			key.Table = OtherTables
+		} else {
+			c.tables[key.Table] = true
+		}
+	}
+	c.counts[key]++
+}
+
+// Snapshot returns a copy of current counts.
+func (c *TableCounter) Snapshot() map[OpTable]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret := make(map[OpTable]int64, len(c.counts))
+	for k, v := range c.counts {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Reset drops all counts and tracked tables. It is safe to call concurrently
+// with OnQuery.
+func (c *TableCounter) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tables = map[string]bool{}
+	c.counts = map[OpTable]int64{}
+}
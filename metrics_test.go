@@ -0,0 +1,46 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestTableCounter(t *testing.T) {
+	cnt := NewTableCounter(2)
+	l, _ := bufLogger(Config{OnQuery: cnt.OnQuery})
+	l.Logger = l.Logger.Level(zerolog.Disabled)
+
+	for _, sql := range []string{
+		"SELECT * FROM users",
+		"SELECT * FROM users WHERE id = 1",
+		"INSERT INTO users (name) VALUES ('x')",
+		"SELECT * FROM orders",
+		"DELETE FROM items",
+	} {
+		sql := sql
+		l.Trace(context.Background(), time.Now(), func() (string, int64) { return sql, 1 }, nil)
+	}
+
+	expect := map[OpTable]int64{
+		{Op: "SELECT", Table: "users"}:     2,
+		{Op: "INSERT", Table: "users"}:     1,
+		{Op: "SELECT", Table: "orders"}:    1,
+		{Op: "DELETE", Table: OtherTables}: 1,
+	}
+	actual := cnt.Snapshot()
+	if len(actual) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, actual)
+	}
+	for k, v := range expect {
+		if actual[k] != v {
+			t.Errorf("%v: expected %d, got %d", k, v, actual[k])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// queryState holds info of a query, shared between callbacks and Trace.
+type queryState struct {
+	id       int64
+	template string // sql without parameters, see LogBothSQL
+
+	// counts of placeholders and parameters, see WarnParamMismatch
+	checked      bool
+	placeholders int
+	params       int
+
+	// queries in flight when it starts including itself, see LogInFlight
+	inFlight int64
+	ended    bool
+}
+
+type queryStateKey struct{}
+
+func stateFrom(ctx context.Context) *queryState {
+	ret, _ := ctx.Value(queryStateKey{}).(*queryState)
+	return ret
+}
+
+// process-wide query id
+var queryID atomic.Int64
+
+// process-wide number of queries in flight
+var inFlight atomic.Int64
+
+// Name implements [gorm.Plugin].
+func (l *Logger) Name() string { return "gorm0log" }
+
+// Initialize implements [gorm.Plugin]. It registers callbacks for features which
+// have to know when a query starts, like LogStart, LogBothSQL,
+// WarnParamMismatch and LogInFlight in [Config]. These features do nothing
+// unless you register the logger as a plugin:
+//
+//	l := &Logger{Logger: log.Logger, Config: Config{LogStart: true}}
+//	db, err := gorm.Open(dialector, &gorm.Config{Logger: l})
+//	if err == nil {
+//		err = db.Use(l)
+//	}
+//
+// Callbacks use the logger of the session, so loggers derived by
+// [gorm.DB.Debug] or [gorm.Session] work as expected. [gorm.DB.Scan] replaces
+// the logger when executing, so these features do not work with it.
+func (l *Logger) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("gorm0log:start", startQuery),
+		cb.Query().Before("gorm:query").Register("gorm0log:start", startQuery),
+		cb.Update().Before("gorm:update").Register("gorm0log:start", startQuery),
+		cb.Delete().Before("gorm:delete").Register("gorm0log:start", startQuery),
+		cb.Row().Before("gorm:row").Register("gorm0log:start", startQuery),
+		cb.Raw().Before("gorm:raw").Register("gorm0log:start", startQuery),
+		cb.Create().After("*").Register("gorm0log:end", endQuery),
+		cb.Query().After("*").Register("gorm0log:end", endQuery),
+		cb.Update().After("*").Register("gorm0log:end", endQuery),
+		cb.Delete().After("*").Register("gorm0log:end", endQuery),
+		cb.Row().After("*").Register("gorm0log:end", endQuery),
+		cb.Raw().After("*").Register("gorm0log:end", endQuery),
+	)
+}
+
+// finds Logger of the session
+func loggerOf(db *gorm.DB) (*Logger, bool) {
+	switch l := db.Logger.(type) {
+	case *Logger:
+		return l, true
+	case *fallbackLogger:
+		return l.primary, true
+	}
+	return nil, false
+}
+
+// callback before executing sql
+func startQuery(db *gorm.DB) {
+	l, ok := loggerOf(db)
+	if !ok || !(l.LogStart || l.LogBothSQL || l.WarnParamMismatch || l.LogInFlight) {
+		return
+	}
+
+	stmt := db.Statement
+	st := &queryState{id: queryID.Add(1)}
+	if l.LogInFlight {
+		st.inFlight = inFlight.Add(1)
+	}
+	ctx := context.WithValue(stmt.Context, queryStateKey{}, st)
+	stmt.Context = ctx
+	if !l.LogStart {
+		return
+	}
+
+	ev := l.dumpLevel(l.Logger)
+	if !ev.Enabled() {
+		return
+	}
+	ev.Func(l.custom(ctx)).Int64("query_id", st.id)
+	if stmt.Table != "" {
+		ev.Str("table", stmt.Table)
+	}
+	if sql := stmt.SQL.String(); sql != "" {
+		// only raw sql is built before executing
+		l.logSQL(ev, sql, nil)
+	}
+	ev.Msg("query started")
+}
+
+// callback after executing sql
+func endQuery(db *gorm.DB) {
+	st := stateFrom(db.Statement.Context)
+	if st == nil || st.inFlight == 0 || st.ended {
+		return
+	}
+	st.ended = true
+	inFlight.Add(-1)
+}
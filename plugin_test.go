@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// opens an in-memory database using l as logger and plugin
+func openDB(t *testing.T, l *Logger) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: l})
+	if err != nil {
+		t.Fatalf("cannot open db: %v", err)
+	}
+	if err = db.Use(l); err != nil {
+		t.Fatalf("cannot register plugin: %v", err)
+	}
+	return db
+}
+
+func TestLogStart(t *testing.T) {
+	l, buf := bufLogger(Config{LogStart: true})
+	db := openDB(t, l)
+
+	if err := db.Exec("SELECT 1").Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["message"] != "query started" || lines[0]["sql"] != "SELECT 1" {
+		t.Errorf("unexpected start message: %v", lines[0])
+	}
+	if lines[1]["message"] != "dump sql" {
+		t.Errorf("unexpected dump message: %v", lines[1])
+	}
+	if id := lines[0]["query_id"]; id == nil || id != lines[1]["query_id"] {
+		t.Errorf("query id mismatch: %v, %v", id, lines[1]["query_id"])
+	}
+}
+
+func TestUseGormCaller(t *testing.T) {
+	l, buf := bufLogger(Config{UseGormCaller: true})
+	db := openDB(t, l)
+
+	if err := db.Exec("SELECT 1").Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	file, _ := lines[0]["source_file"].(string)
+	if !strings.HasSuffix(file, "/plugin_test.go") {
+		t.Errorf("unexpected source file: %v", lines[0])
+	}
+}
+
+func TestLogBothSQL(t *testing.T) {
+	l, buf := bufLogger(Config{LogBothSQL: true})
+	db := openDB(t, l)
+
+	if err := db.Exec("SELECT ?", 1).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.Exec("SELECT 2").Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["sql"] != "SELECT 1" || lines[0]["sql_template"] != "SELECT ?" {
+		t.Errorf("unexpected message with parameters: %v", lines[0])
+	}
+	if _, ok := lines[1]["sql_template"]; ok {
+		t.Errorf("unexpected template without parameters: %v", lines[1])
+	}
+	if _, ok := lines[0]["query_id"]; ok {
+		t.Errorf("unexpected query id: %v", lines[0])
+	}
+}
+
+func TestTxID(t *testing.T) {
+	l, buf := bufLogger(Config{Customize: LogTxID("tx")})
+	db := openDB(t, l)
+
+	ctx := ContextWithTxID(context.Background())
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT 1").Error; err != nil {
+			return err
+		}
+		return tx.Exec("SELECT 2").Error
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = db.Exec("SELECT 3").Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %s", len(lines), buf.String())
+	}
+	if id, _ := lines[0]["tx"].(string); id == "" || id != lines[1]["tx"] {
+		t.Errorf("tx id mismatch: %v, %v", lines[0]["tx"], lines[1]["tx"])
+	}
+	if _, ok := lines[2]["tx"]; ok {
+		t.Errorf("unexpected tx id: %v", lines[2])
+	}
+}
+
+func TestWarnParamMismatch(t *testing.T) {
+	l, buf := bufLogger(Config{WarnParamMismatch: true})
+	db := openDB(t, l)
+
+	if err := db.Exec("SELECT ?, '?'", 1).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Exec("SELECT ?, ?", 1)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if _, ok := lines[0]["param_mismatch"]; ok {
+		t.Errorf("unexpected mismatch: %v", lines[0])
+	}
+	if lines[1]["param_mismatch"] != true || lines[1]["placeholders"] != float64(2) || lines[1]["params"] != float64(1) {
+		t.Errorf("expected mismatch: %v", lines[1])
+	}
+}
+
+func TestLogInFlight(t *testing.T) {
+	l, buf := bufLogger(Config{LogInFlight: true})
+	db := openDB(t, l)
+
+	for i := 0; i < 2; i++ {
+		if err := db.Exec("SELECT 1").Error; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	lines := parseLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		// queries are sequential, so only itself is in flight
+		if n, _ := line["in_flight"].(float64); n != 1 {
+			t.Errorf("expected in_flight 1, got %v", line["in_flight"])
+		}
+	}
+}
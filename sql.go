@@ -0,0 +1,685 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// skips leading spaces and comments
+func trimSQL(sql string) string {
+	for {
+		sql = strings.TrimLeftFunc(sql, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			idx := strings.IndexByte(sql, '\n')
+			if idx < 0 {
+				return ""
+			}
+			sql = sql[idx+1:]
+		case strings.HasPrefix(sql, "/*"):
+			idx := strings.Index(sql, "*/")
+			if idx < 0 {
+				return ""
+			}
+			sql = sql[idx+2:]
+		default:
+			return sql
+		}
+	}
+}
+
+// operation detects type of sql statement, which is the first keyword in upper
+// case like "SELECT" or "INSERT".
+func operation(sql string) string {
+	sql = trimSQL(sql)
+	idx := strings.IndexFunc(sql, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if idx >= 0 {
+		sql = sql[:idx]
+	}
+	return strings.ToUpper(sql)
+}
+
+type tokenKind int
+
+const (
+	tokSpace   tokenKind = iota
+	tokComment           // -- or /* */
+	tokWord              // keyword or bare identifier
+	tokQuoted            // identifier or string quoted by ` or "
+	tokString            // string quoted by '
+	tokNumber
+	tokOther // single character like operators and punctuations
+)
+
+// sqlToken is a piece of sql statement, joining text of all tokens gives the
+// original statement.
+type sqlToken struct {
+	kind tokenKind
+	text string
+}
+
+func isWordStart(b byte) bool {
+	return b == '_' || b >= 0x80 ||
+		(b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isWordPart(b byte) bool {
+	return isWordStart(b) || b == '$' || (b >= '0' && b <= '9')
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// ends of quoted text starts at s[0], quotes are escaped by doubling them
+func quoteEnd(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		if s[i] != q {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == q {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(s)
+}
+
+// scanSQL splits sql into tokens. It is a simple lexer which is good enough for
+// sql generated by gorm, not a parser.
+func scanSQL(sql string) []sqlToken {
+	ret := make([]sqlToken, 0, 32)
+	for len(sql) > 0 {
+		var (
+			kind tokenKind
+			n    int
+			b    = sql[0]
+		)
+		switch {
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			kind = tokSpace
+			for n = 1; n < len(sql); n++ {
+				if c := sql[n]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					break
+				}
+			}
+		case strings.HasPrefix(sql, "--"):
+			kind = tokComment
+			n = strings.IndexByte(sql, '\n')
+			if n < 0 {
+				n = len(sql)
+			}
+		case strings.HasPrefix(sql, "/*"):
+			kind = tokComment
+			n = strings.Index(sql, "*/") + 2
+			if n < 2 {
+				n = len(sql)
+			}
+		case b == '\'':
+			kind, n = tokString, quoteEnd(sql)
+		case b == '"' || b == '`':
+			kind, n = tokQuoted, quoteEnd(sql)
+		case isDigit(b):
+			kind = tokNumber
+			for n = 1; n < len(sql) && (isDigit(sql[n]) || sql[n] == '.'); n++ {
+			}
+		case isWordStart(b):
+			kind = tokWord
+			for n = 1; n < len(sql) && isWordPart(sql[n]); n++ {
+			}
+		default:
+			kind, n = tokOther, 1
+		}
+		ret = append(ret, sqlToken{kind: kind, text: sql[:n]})
+		sql = sql[n:]
+	}
+	return ret
+}
+
+// tokens without spaces and comments
+func meaningful(tokens []sqlToken) []sqlToken {
+	ret := make([]sqlToken, 0, len(tokens))
+	for _, t := range tokens {
+		if t.kind != tokSpace && t.kind != tokComment {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// checks if t is specified keyword
+func (t sqlToken) is(keyword string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, keyword)
+}
+
+// aggregate functions which returns single row without GROUP BY
+var aggregates = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+
+// missingLimit detects if sql is a SELECT statement without LIMIT clause.
+//
+// It's a heuristic and reports false if unsure: statements with subquery, UNION
+// or CTE, statements without FROM, and statements selecting only aggregate
+// functions without GROUP BY are considered fine.
+func missingLimit(sql string) bool {
+	tokens := meaningful(scanSQL(sql))
+	if len(tokens) == 0 || !tokens[0].is("SELECT") {
+		return false
+	}
+
+	from, group := -1, false
+	for i, t := range tokens[1:] {
+		switch {
+		case t.is("SELECT"), t.is("LIMIT"), t.is("FETCH"), t.is("TOP"):
+			return false
+		case t.is("FROM"):
+			if from < 0 {
+				from = i + 1
+			}
+		case t.is("GROUP"):
+			group = true
+		}
+	}
+	if from < 0 {
+		return false
+	}
+	if group {
+		return true
+	}
+
+	// check if every column in select list is an aggregate function
+	agg := false
+	for i := 1; i < from; i++ {
+		t := tokens[i]
+		if t.is("DISTINCT") {
+			continue
+		}
+		agg = false
+		for _, fn := range aggregates {
+			if t.is(fn) && i+1 < from && tokens[i+1].text == "(" {
+				agg = true
+				break
+			}
+		}
+		if !agg {
+			return true
+		}
+
+		// skip to next column
+		depth := 0
+		for i++; i < from; i++ {
+			switch tokens[i].text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+			if depth == 0 && tokens[i].text == "," {
+				break
+			}
+		}
+	}
+	return !agg
+}
+
+// hasReturning detects if sql has a RETURNING clause.
+func hasReturning(sql string) bool {
+	for _, t := range scanSQL(sql) {
+		if t.is("RETURNING") {
+			return true
+		}
+	}
+	return false
+}
+
+// RETURNING clause with more columns is summarized
+const maxReturning = 3
+
+// summarizeReturning replaces long column list of RETURNING clause with number
+// of columns, like "RETURNING (…5 cols)".
+func summarizeReturning(sql string) string {
+	tokens := scanSQL(sql)
+	for i, t := range tokens {
+		if !t.is("RETURNING") {
+			continue
+		}
+
+		cols, depth, end := 1, 0, len(tokens)
+		for j := i + 1; j < len(tokens) && end == len(tokens); j++ {
+			switch tokens[j].text {
+			case "(":
+				depth++
+			case ")":
+				// end of enclosing parentheses, like a CTE
+				if depth--; depth < 0 {
+					end = j
+				}
+			case ",":
+				if depth == 0 {
+					cols++
+				}
+			case ";":
+				if depth == 0 {
+					end = j
+				}
+			}
+		}
+		if cols <= maxReturning {
+			return sql
+		}
+
+		var b strings.Builder
+		for _, t := range tokens[:i+1] {
+			b.WriteString(t.text)
+		}
+		b.WriteString(" (…" + strconv.Itoa(cols) + " cols)")
+		for _, t := range tokens[end:] {
+			b.WriteString(t.text)
+		}
+		return b.String()
+	}
+	return sql
+}
+
+// unquotes text quoted by ', " or `
+func unquote(s string) (string, byte) {
+	if len(s) < 2 {
+		return s, 0
+	}
+	q := s[0]
+	s = s[1 : len(s)-1]
+	return strings.ReplaceAll(s, string(q)+string(q), string(q)), q
+}
+
+// quotes s with q, q in s is escaped by doubling it
+func quote(s string, q byte) string {
+	x := string(q)
+	return x + strings.ReplaceAll(s, x, x+x) + x
+}
+
+// maskColumns replaces values in "column = value" pairs using maskers, which is
+// keyed by column name in lower case.
+//
+// Only literal values compared or assigned with "=" are detected, which covers
+// WHERE conditions and SET clauses generated by gorm. Values in INSERT, IN lists
+// or other operators are not masked.
+func maskColumns(sql string, maskers map[string]func(string) string) string {
+	tokens := scanSQL(sql)
+	// indexes of meaningful tokens
+	idx := make([]int, 0, len(tokens))
+	for i, t := range tokens {
+		if t.kind != tokSpace && t.kind != tokComment {
+			idx = append(idx, i)
+		}
+	}
+
+	masked := false
+	for i := 0; i+2 < len(idx); i++ {
+		col, op, val := tokens[idx[i]], tokens[idx[i+1]], tokens[idx[i+2]]
+		if op.text != "=" || (col.kind != tokWord && col.kind != tokQuoted) {
+			continue
+		}
+		name := col.text
+		if col.kind == tokQuoted {
+			name, _ = unquote(name)
+		}
+		fn, ok := maskers[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+
+		switch val.kind {
+		case tokString, tokQuoted:
+			v, q := unquote(val.text)
+			tokens[idx[i+2]].text = quote(fn(v), q)
+		case tokNumber:
+			tokens[idx[i+2]].text = fn(val.text)
+		default:
+			continue
+		}
+		masked = true
+		i += 2
+	}
+	if !masked {
+		return sql
+	}
+
+	var b strings.Builder
+	b.Grow(len(sql))
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// statements which change or inspect schema
+var schemaOps = map[string]bool{
+	"CREATE": true, "ALTER": true, "DROP": true, "RENAME": true,
+	"COMMENT": true, "PRAGMA": true, "SHOW": true, "DESCRIBE": true,
+}
+
+// catalogs queried to inspect schema
+var catalogs = []string{"information_schema", "sqlite_master", "sqlite_schema", "pg_catalog"}
+
+// isMigration detects if sql is a schema operation, like those executed by
+// [gorm.DB.AutoMigrate]: DDL statements, and statements inspecting schema like
+// SHOW or SELECT from information_schema.
+func isMigration(sql string) bool {
+	tokens := meaningful(scanSQL(sql))
+	if len(tokens) == 0 || tokens[0].kind != tokWord {
+		return false
+	}
+	op := strings.ToUpper(tokens[0].text)
+	if schemaOps[op] {
+		return true
+	}
+	if op != "SELECT" {
+		return false
+	}
+
+	for _, t := range tokens[1:] {
+		name := t.text
+		switch t.kind {
+		case tokQuoted:
+			name, _ = unquote(name)
+		case tokWord:
+		default:
+			continue
+		}
+		for _, c := range catalogs {
+			if strings.EqualFold(name, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reads a possibly qualified table name starting at tokens[i], like `db`.`users`
+func tableAt(tokens []sqlToken, i int) string {
+	var parts []string
+	for ; i < len(tokens); i += 2 {
+		t := tokens[i]
+		name := t.text
+		switch t.kind {
+		case tokQuoted:
+			name, _ = unquote(name)
+		case tokWord:
+		default:
+			return strings.Join(parts, ".")
+		}
+		parts = append(parts, name)
+		if i+1 >= len(tokens) || tokens[i+1].text != "." {
+			break
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// table detects main table of sql: the table after FROM for SELECT and DELETE,
+// INTO for INSERT, and UPDATE for UPDATE. Empty string is returned if not found.
+func table(sql string) string {
+	tokens := meaningful(scanSQL(sql))
+	if len(tokens) == 0 {
+		return ""
+	}
+	var kw string
+	switch {
+	case tokens[0].is("SELECT"), tokens[0].is("DELETE"):
+		kw = "FROM"
+	case tokens[0].is("INSERT"), tokens[0].is("REPLACE"):
+		kw = "INTO"
+	case tokens[0].is("UPDATE"):
+		return tableAt(tokens, 1)
+	default:
+		return ""
+	}
+
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.is(kw):
+			return tableAt(tokens, i+1)
+		}
+	}
+	return ""
+}
+
+// tables detects distinct tables referenced by sql in order of appearance,
+// including those in subqueries. Tables are found after FROM (and following
+// commas), JOIN, INTO, USING and leading UPDATE. Aliases, subqueries in FROM
+// clause and names defined by WITH are not counted, and FROM in functions like
+// EXTRACT is ignored.
+func tables(sql string) []string {
+	tokens := meaningful(scanSQL(sql))
+	ctes := map[string]bool{}
+	if len(tokens) > 0 && tokens[0].is("WITH") {
+		depth := 0
+		for i, t := range tokens {
+			switch {
+			case t.text == "(":
+				depth++
+			case t.text == ")":
+				depth--
+			case depth == 0 && t.is("AS") && i > 0 && i+1 < len(tokens) && tokens[i+1].text == "(":
+				ctes[tableAt(tokens, i-1)] = true
+			}
+		}
+	}
+
+	var ret []string
+	seen := map[string]bool{}
+	// reads references starting at i, returns index after them
+	refs := func(i int, list bool) int {
+		for i < len(tokens) {
+			t := tokens[i]
+			if t.kind == tokWord && keywords[strings.ToUpper(t.text)] {
+				return i
+			}
+			name := tableAt(tokens, i)
+			if name == "" {
+				return i
+			}
+			if !seen[name] && !ctes[name] {
+				seen[name] = true
+				ret = append(ret, name)
+			}
+			for i+1 < len(tokens) && tokens[i+1].text == "." {
+				i += 2
+			}
+			i++
+
+			// alias
+			if i < len(tokens) && tokens[i].is("AS") {
+				i += 2
+			} else if i < len(tokens) && (tokens[i].kind == tokQuoted ||
+				tokens[i].kind == tokWord && !keywords[strings.ToUpper(tokens[i].text)]) {
+				i++
+			}
+			if !list || i >= len(tokens) || tokens[i].text != "," {
+				return i
+			}
+			i++
+		}
+		return i
+	}
+
+	// whether each level of parentheses is a query
+	stack := []bool{true}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.text == "(":
+			stack = append(stack, i+1 < len(tokens) &&
+				(tokens[i+1].is("SELECT") || tokens[i+1].is("WITH")))
+		case t.text == ")":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case !stack[len(stack)-1]:
+		case t.is("FROM"):
+			i = refs(i+1, true) - 1
+		case t.is("JOIN"), t.is("INTO"), t.is("USING"):
+			i = refs(i+1, false) - 1
+		case t.is("UPDATE") && (i == 0 || tokens[i-1].text == ")"):
+			i = refs(i+1, false) - 1
+		}
+	}
+	return ret
+}
+
+// common sql keywords recased by recase
+var keywords = func() map[string]bool {
+	ret := map[string]bool{}
+	for _, k := range strings.Fields(`
+		ADD ALL ALTER AND ANY AS ASC BEGIN BETWEEN BY CASCADE CASE CHECK COLUMN
+		COMMIT CONFLICT CONSTRAINT CREATE CROSS DEFAULT DELETE DESC DISTINCT DO
+		DROP ELSE END ESCAPE EXCEPT EXISTS FALSE FETCH FIRST FOR FOREIGN FROM FULL
+		GROUP HAVING IGNORE ILIKE IN INDEX INNER INSERT INTERSECT INTO IS JOIN KEY
+		LEFT LIKE LIMIT LOCK NOT NOTHING NULL OFFSET ON OR ORDER OUTER PRIMARY
+		REFERENCES REPLACE RETURNING RIGHT ROLLBACK ROW ROWS SAVEPOINT SELECT SET
+		SHARE TABLE THEN TOP TRUE UNION UNIQUE UPDATE USING VALUES WHEN WHERE WITH
+	`) {
+		ret[k] = true
+	}
+	return ret
+}()
+
+// recase changes case of recognized keywords in sql, identifiers and strings are
+// kept intact. Quoted identifiers are never recognized as keywords.
+func recase(sql string, upper bool) string {
+	tokens := scanSQL(sql)
+	changed := false
+	for i, t := range tokens {
+		if t.kind != tokWord || !keywords[strings.ToUpper(t.text)] {
+			continue
+		}
+		x := strings.ToLower(t.text)
+		if upper {
+			x = strings.ToUpper(t.text)
+		}
+		if x != t.text {
+			tokens[i].text = x
+			changed = true
+		}
+	}
+	if !changed {
+		return sql
+	}
+
+	var b strings.Builder
+	b.Grow(len(sql))
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// fingerprint normalizes sql by replacing literals with "?", and collapsing
+// spaces and comments into single space, so statements different only in
+// parameters have same fingerprint.
+func fingerprint(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+	space := false
+	for _, t := range scanSQL(sql) {
+		switch t.kind {
+		case tokSpace, tokComment:
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		switch t.kind {
+		case tokString, tokNumber:
+			b.WriteByte('?')
+		default:
+			b.WriteString(t.text)
+		}
+	}
+	return b.String()
+}
+
+// placeholders counts parameter placeholders in sql, which are "?" or "$n"
+// (counted as the largest n). Placeholders in strings and comments are ignored.
+func placeholders(sql string) int {
+	tokens := scanSQL(sql)
+	q, dollar := 0, 0
+	for i, t := range tokens {
+		switch {
+		case t.kind != tokOther:
+		case t.text == "?":
+			q++
+		case t.text == "$" && i+1 < len(tokens) && tokens[i+1].kind == tokNumber:
+			if n, err := strconv.Atoi(tokens[i+1].text); err == nil && n > dollar {
+				dollar = n
+			}
+		}
+	}
+	return q + dollar
+}
+
+// mysqlDigest normalizes sql like statement digest of MySQL: literals are
+// replaced with "?", keywords are upper cased, tokens are separated by single
+// space, lists of literals are collapsed into "(...)", and multiple rows of
+// such lists are collapsed into "(...) /* , ... */".
+func mysqlDigest(sql string) string {
+	var toks []string
+	for _, t := range scanSQL(sql) {
+		switch t.kind {
+		case tokSpace, tokComment:
+			continue
+		case tokString, tokNumber:
+			toks = append(toks, "?")
+		case tokWord:
+			if x := strings.ToUpper(t.text); keywords[x] {
+				toks = append(toks, x)
+			} else {
+				toks = append(toks, t.text)
+			}
+		default:
+			toks = append(toks, t.text)
+			if t.text == ")" {
+				toks = collapseList(toks)
+			}
+		}
+	}
+	return strings.Join(toks, " ")
+}
+
+// collapseList collapses list of literals at the end of toks, which ends with
+// ")", see mysqlDigest.
+func collapseList(toks []string) []string {
+	i := len(toks) - 2
+	for ; i >= 0 && toks[i] == "?"; i -= 2 {
+		if i == 0 || toks[i-1] == "(" {
+			break
+		}
+		if toks[i-1] != "," {
+			return toks
+		}
+	}
+	if i < 1 || toks[i] != "?" || toks[i-1] != "(" {
+		return toks
+	}
+	toks = append(toks[:i-1], "(...)")
+
+	// multiple rows
+	if n := len(toks); n >= 3 && toks[n-2] == "," &&
+		(toks[n-3] == "(...)" || toks[n-3] == "(...) /* , ... */") {
+		toks = append(toks[:n-3], "(...) /* , ... */")
+	}
+	return toks
+}
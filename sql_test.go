@@ -0,0 +1,243 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOperation(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{sql: "SELECT * FROM users", expect: "SELECT"},
+		{sql: "  insert into users (name) values ('x')", expect: "INSERT"},
+		{sql: "-- comment\nUPDATE users SET name = 'x'", expect: "UPDATE"},
+		{sql: "/* comment */DELETE FROM users", expect: "DELETE"},
+		{sql: "", expect: ""},
+	}
+
+	for _, c := range cases {
+		if actual := operation(c.sql); actual != c.expect {
+			t.Errorf("%q: expected %s, got %s", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestMissingLimit(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect bool
+	}{
+		{sql: "SELECT * FROM `users`", expect: true},
+		{sql: "select * from users where name = 'limit'", expect: true},
+		{sql: "SELECT * FROM `users` WHERE `id` = 1 ORDER BY `users`.`id` LIMIT 1", expect: false},
+		{sql: "SELECT count(*) FROM `users`", expect: false},
+		{sql: "SELECT count(*), max(`id`) FROM `users`", expect: false},
+		{sql: "SELECT count(*), `name` FROM `users` GROUP BY `name`", expect: true},
+		{sql: "SELECT count(*) AS c, name FROM users", expect: true},
+		{sql: "SELECT * FROM users WHERE id IN (SELECT uid FROM orders)", expect: false},
+		{sql: "WITH x AS (SELECT 1) SELECT * FROM x", expect: false},
+		{sql: "SELECT 1", expect: false},
+		{sql: "INSERT INTO `users` (`name`) VALUES (\"x\")", expect: false},
+		{sql: "/* comment */ SELECT * FROM users", expect: true},
+	}
+
+	for _, c := range cases {
+		if actual := missingLimit(c.sql); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestHasReturning(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect bool
+	}{
+		{sql: `INSERT INTO "users" ("name") VALUES ('x') RETURNING "id"`, expect: true},
+		{sql: `insert into users (name) values ('x') returning id`, expect: true},
+		{sql: `INSERT INTO "users" ("name") VALUES ('returning')`, expect: false},
+		{sql: `SELECT "returning" FROM "users"`, expect: false},
+	}
+
+	for _, c := range cases {
+		if actual := hasReturning(c.sql); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestSummarizeReturning(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{
+			sql:    "INSERT INTO `users` (`name`) VALUES (\"x\") RETURNING `id`",
+			expect: "INSERT INTO `users` (`name`) VALUES (\"x\") RETURNING `id`",
+		},
+		{
+			sql:    `INSERT INTO "users" ("a") VALUES ('x') RETURNING "id","a","b","c"`,
+			expect: `INSERT INTO "users" ("a") VALUES ('x') RETURNING (…4 cols)`,
+		},
+		{
+			sql:    `INSERT INTO t (a) VALUES (1) RETURNING id, a, coalesce(b, c), d;`,
+			expect: `INSERT INTO t (a) VALUES (1) RETURNING (…4 cols);`,
+		},
+		{
+			sql:    `WITH ins AS (INSERT INTO t (a) VALUES (1) RETURNING id, a, b, c) SELECT * FROM ins`,
+			expect: `WITH ins AS (INSERT INTO t (a) VALUES (1) RETURNING (…4 cols)) SELECT * FROM ins`,
+		},
+		{
+			sql:    `SELECT 'RETURNING a, b, c, d'`,
+			expect: `SELECT 'RETURNING a, b, c, d'`,
+		},
+	}
+
+	for _, c := range cases {
+		if actual := summarizeReturning(c.sql); actual != c.expect {
+			t.Errorf("%s: expected %s, got %s", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestMaskColumns(t *testing.T) {
+	maskers := map[string]func(string) string{
+		"email": func(string) string { return "<email>" },
+		"ssn":   func(s string) string { return "***" + s[len(s)-2:] },
+	}
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{
+			sql:    "SELECT * FROM `users` WHERE `users`.`email` = \"a@b.c\" AND `id` = 1",
+			expect: "SELECT * FROM `users` WHERE `users`.`email` = \"<email>\" AND `id` = 1",
+		},
+		{
+			sql:    `UPDATE "users" SET "Email"='it''s',"ssn"=123456 WHERE id = 1`,
+			expect: `UPDATE "users" SET "Email"='<email>',"ssn"=***56 WHERE id = 1`,
+		},
+		{
+			sql:    `SELECT 'email = 1' FROM t WHERE email IN ('x')`,
+			expect: `SELECT 'email = 1' FROM t WHERE email IN ('x')`,
+		},
+	}
+
+	for _, c := range cases {
+		if actual := maskColumns(c.sql, maskers); actual != c.expect {
+			t.Errorf("%s: expected %s, got %s", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestIsMigration(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect bool
+	}{
+		{sql: "CREATE TABLE `users` (`id` integer)", expect: true},
+		{sql: "ALTER TABLE `users` ADD `name` text", expect: true},
+		{sql: "SELECT count(*) FROM sqlite_master WHERE type='table' AND name=\"users\"", expect: true},
+		{sql: "SELECT count(*) FROM information_schema.tables WHERE table_name = 'users'", expect: true},
+		{sql: "SELECT * FROM \"pg_catalog\".\"pg_tables\"", expect: true},
+		{sql: "SELECT * FROM `users` WHERE `name` = 'information_schema'", expect: false},
+		{sql: "INSERT INTO `users` (`name`) VALUES ('x')", expect: false},
+		{sql: "", expect: false},
+	}
+
+	for _, c := range cases {
+		if actual := isMigration(c.sql); actual != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{sql: "SELECT * FROM `users` WHERE `id` = 1", expect: "users"},
+		{sql: "SELECT count(*) FROM \"public\".\"users\"", expect: "public.users"},
+		{sql: "SELECT (SELECT 1 FROM a) FROM b", expect: "b"},
+		{sql: "INSERT INTO users (name) VALUES ('x')", expect: "users"},
+		{sql: "UPDATE `users` SET `name` = 'x'", expect: "users"},
+		{sql: "DELETE FROM users WHERE id = 1", expect: "users"},
+		{sql: "SELECT 1", expect: ""},
+		{sql: "CREATE TABLE users (id int)", expect: ""},
+	}
+
+	for _, c := range cases {
+		if actual := table(c.sql); actual != c.expect {
+			t.Errorf("%s: expected %q, got %q", c.sql, c.expect, actual)
+		}
+	}
+}
+
+func TestRecase(t *testing.T) {
+	sql := "select `select`, name from users where name = 'from' Limit 1"
+	if actual, expect := recase(sql, true), "SELECT `select`, name FROM users WHERE name = 'from' LIMIT 1"; actual != expect {
+		t.Errorf("upper: expected %s, got %s", expect, actual)
+	}
+	if actual, expect := recase(sql, false), "select `select`, name from users where name = 'from' limit 1"; actual != expect {
+		t.Errorf("lower: expected %s, got %s", expect, actual)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := fingerprint("SELECT * FROM users  WHERE id = 1 AND name = 'x' /* c */ LIMIT 10")
+	b := fingerprint("SELECT * FROM users WHERE id = 22 AND name = 'it''s'\nLIMIT 10")
+	if expect := "SELECT * FROM users WHERE id = ? AND name = ? LIMIT ?"; a != expect || b != expect {
+		t.Errorf("expected %s, got %s and %s", expect, a, b)
+	}
+}
+
+func TestMySQLDigest(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{"select * from t where id = 1", "SELECT * FROM t WHERE id = ?"},
+		{"SELECT * FROM `t` WHERE `id` IN (1, 2,3)", "SELECT * FROM `t` WHERE `id` IN (...)"},
+		{"INSERT INTO t (a,b) VALUES (1,'x'),(2,'y'),(3,'z')", "INSERT INTO t ( a , b ) VALUES (...) /* , ... */"},
+		{"INSERT INTO t VALUES (1)", "INSERT INTO t VALUES (...)"},
+		{"SELECT COUNT(*) FROM t", "SELECT COUNT ( * ) FROM t"},
+		{"SELECT * FROM t WHERE a IN (1, b)", "SELECT * FROM t WHERE a IN ( ? , b )"},
+	}
+	for _, c := range cases {
+		if got := mysqlDigest(c.sql); got != c.expect {
+			t.Errorf("%s: expected %s, got %s", c.sql, c.expect, got)
+		}
+	}
+}
+
+func TestTables(t *testing.T) {
+	cases := []struct {
+		sql    string
+		expect []string
+	}{
+		{sql: "SELECT * FROM `users` AS u JOIN `orders` o ON o.user_id = u.id", expect: []string{"users", "orders"}},
+		{sql: "SELECT * FROM a, b x, a WHERE a.id = x.id", expect: []string{"a", "b"}},
+		{sql: "SELECT * FROM a WHERE id IN (SELECT a_id FROM public.b)", expect: []string{"a", "public.b"}},
+		{sql: "SELECT * FROM (SELECT * FROM a) t LEFT JOIN b USING (id)", expect: []string{"a", "b"}},
+		{sql: "SELECT EXTRACT(YEAR FROM created_at) FROM a", expect: []string{"a"}},
+		{sql: "WITH x AS (SELECT * FROM a) SELECT * FROM x JOIN b ON b.id = x.id", expect: []string{"a", "b"}},
+		{sql: "INSERT INTO a (id) SELECT id FROM b", expect: []string{"a", "b"}},
+		{sql: "UPDATE a SET n = 1 WHERE id IN (SELECT id FROM b)", expect: []string{"a", "b"}},
+		{sql: "INSERT INTO a (id) VALUES (1) ON CONFLICT (id) DO UPDATE SET id = 2", expect: []string{"a"}},
+		{sql: "SELECT * FROM a FOR UPDATE", expect: []string{"a"}},
+		{sql: "SELECT 1", expect: nil},
+	}
+
+	for _, c := range cases {
+		if actual := tables(c.sql); !reflect.DeepEqual(actual, c.expect) {
+			t.Errorf("%s: expected %q, got %q", c.sql, c.expect, actual)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// max fingerprints remembered by stmtSeen, it is cleared when full
+const maxStmtSeen = 4096
+
+// stmtSeen remembers fingerprints of executed sql, process-wide
+var stmtSeen = struct {
+	lock sync.Mutex
+	set  map[uint64]struct{}
+}{set: map[uint64]struct{}{}}
+
+// stmtCache guesses if sql hits prepared statement cache, by checking if same
+// fingerprint has been seen. It returns "hit" or "miss".
+func stmtCache(sql string) string {
+	h := fnv.New64a()
+	h.Write([]byte(fingerprint(sql)))
+	key := h.Sum64()
+
+	stmtSeen.lock.Lock()
+	defer stmtSeen.lock.Unlock()
+	if _, ok := stmtSeen.set[key]; ok {
+		return "hit"
+	}
+	if len(stmtSeen.set) >= maxStmtSeen {
+		stmtSeen.set = map[uint64]struct{}{}
+	}
+	stmtSeen.set[key] = struct{}{}
+	return "miss"
+}
+
+// forgets every seen fingerprint
+func resetStmtSeen() {
+	stmtSeen.lock.Lock()
+	defer stmtSeen.lock.Unlock()
+	stmtSeen.set = map[uint64]struct{}{}
+}
@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// max events watched by lateWrites, oldest one is dropped when full
+const maxWatchedEvents = 128
+
+// lastLine is a writer keeping the last line written to it.
+type lastLine struct{ line []byte }
+
+func (w *lastLine) Write(p []byte) (int, error) {
+	w.line = append(w.line[:0], p...)
+	return len(p), nil
+}
+
+// watchedEvent is an event passed to user callbacks, from a private logger
+// writing to out. It is sent once the callback returns, and taken back from the
+// pool of zerolog by the private logger, so late writes to it do no harm.
+type watchedEvent struct {
+	ev  *zerolog.Event
+	out *lastLine
+}
+
+// written sends the event again, and reports if anything is written to it since
+// it was taken back.
+func (e *watchedEvent) written() bool {
+	if len(e.out.line) > 0 {
+		// sent by user
+		return true
+	}
+	e.ev.Msg("")
+	ret := false
+	parseFields(bytes.NewReader(e.out.line), func(string, json.RawMessage) {
+		ret = true
+	})
+	return ret
+}
+
+// lateWrites watches events passed to user callbacks of a Logger in
+// StrictEvents mode, so late writes to them can be detected.
+type lateWrites struct {
+	lock   sync.Mutex
+	events []*watchedEvent
+}
+
+// capture is like captureFields, but fn writes to an event which is watched
+// after fn returns.
+func (w *lateWrites) capture(fn func(*zerolog.Event), add func(key string, val json.RawMessage)) {
+	out := &lastLine{}
+	l := zerolog.New(out)
+	ev := l.Log()
+	if ev == nil {
+		return
+	}
+	ev.Func(fn).Msg("")
+	// the pool is a sync.Pool, so the event is usually on top of it, or right
+	// below an event put by others
+	back := l.Log() == ev || l.Log() == ev
+	line := out.line
+	out.line = nil
+	parseFields(bytes.NewReader(line), add)
+	if !back {
+		return
+	}
+
+	e := &watchedEvent{ev: ev, out: out}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.events) >= maxWatchedEvents {
+		w.events = w.events[1:]
+	}
+	w.events = append(w.events, e)
+}
+
+// check reports number of watched events which are written after their
+// callbacks return, and stops watching them.
+func (w *lateWrites) check() int {
+	w.lock.Lock()
+	events := w.events
+	w.events = nil
+	w.lock.Unlock()
+
+	n := 0
+	for _, e := range events {
+		if e.written() {
+			n++
+		}
+	}
+	return n
+}
+
+// logs a diagnostic message if any event is written after its callback
+// returns, see StrictEvents
+func (l *Logger) reportLateWrites() {
+	if !l.StrictEvents {
+		return
+	}
+	if n := l.states().late.check(); n > 0 {
+		l.tagKind(l.Logger.Error(), KindError).
+			Int("events", n).
+			Msg("customize function wrote to an event after it was sent")
+	}
+}
@@ -0,0 +1,178 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Entry is a message captured by [TestLogger].
+type Entry struct {
+	Level   zerolog.Level
+	Message string
+	Kind    MessageKind
+	// Every field of the message, including level and message.
+	Fields map[string]any
+}
+
+// TestLogger is a [Logger] at Trace level which captures every message, to
+// verify logging behavior of your code in tests:
+//
+//	l := NewTestLogger(Config{SlowThreshold: time.Second})
+//	db, _ := gorm.Open(dialector, &gorm.Config{Logger: l})
+//	// run your code
+//	if err := l.AssertNoErrors(); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// Kind of messages is reported by the logger in an internal field, which is not
+// in Fields. Messages sharing fields of slow sql messages, like those of
+// WarnMissingLimit, are KindSlow. Diagnostic message of StrictEvents is
+// KindError. Messages from gorm (Info, Warn and Error) are KindNone.
+type TestLogger struct {
+	*Logger
+	w *captureWriter
+}
+
+// NewTestLogger creates a [TestLogger] with c.
+func NewTestLogger(c Config) *TestLogger {
+	c.captureKind = true
+	w := &captureWriter{}
+	return &TestLogger{
+		Logger: &Logger{
+			Logger: zerolog.New(w).Level(zerolog.TraceLevel),
+			Config: c,
+		},
+		w: w,
+	}
+}
+
+// NewWithCapture creates a [Logger] at Trace level which writes to stdout, and
+// captures messages in returned [TestLogger] at the same time, so you can see
+// and verify logs during development. Use the Logger with gorm, and the
+// TestLogger for assertions only. It is intended for development and testing.
+func NewWithCapture(c Config) (*Logger, *TestLogger) {
+	t := NewTestLogger(c)
+	c.captureKind = true
+	l := &Logger{
+		Logger: zerolog.New(zerolog.MultiLevelWriter(hideKind{os.Stdout}, t.w)).
+			Level(zerolog.TraceLevel),
+		Config: c,
+	}
+	return l, t
+}
+
+// field holding kind of messages, added only for TestLogger
+const kindKey = "_gorm0log_kind"
+
+// tagKind adds kind of the message to ev if it is captured by TestLogger.
+func (l *Logger) tagKind(ev *zerolog.Event, kind MessageKind) *zerolog.Event {
+	if !l.captureKind {
+		return ev
+	}
+	return ev.Int(kindKey, int(kind))
+}
+
+// hideKind removes kind of messages before writing to w
+type hideKind struct{ w io.Writer }
+
+func (h hideKind) Write(p []byte) (int, error) {
+	n := len(p)
+	key := []byte(`"` + kindKey + `":`)
+	if i := bytes.Index(p, key); i >= 0 {
+		j := i + len(key)
+		for j < len(p) && p[j] >= '0' && p[j] <= '9' {
+			j++
+		}
+		if i > 0 && p[i-1] == ',' {
+			i--
+		} else if j < len(p) && p[j] == ',' {
+			j++
+		}
+		p = append(p[:i:i], p[j:]...)
+	}
+	if _, err := h.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// captureWriter parses and saves every message
+type captureWriter struct {
+	lock    sync.Mutex
+	entries []Entry
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	fields := map[string]any{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return 0, err
+	}
+
+	e := Entry{Fields: fields}
+	e.Message, _ = fields[zerolog.MessageFieldName].(string)
+	if lv, ok := fields[zerolog.LevelFieldName].(string); ok {
+		e.Level, _ = zerolog.ParseLevel(lv)
+	}
+	if kind, ok := fields[kindKey].(float64); ok {
+		e.Kind = MessageKind(kind)
+		delete(fields, kindKey)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.entries = append(w.entries, e)
+	return len(p), nil
+}
+
+// Entries returns captured messages in order.
+func (t *TestLogger) Entries() []Entry {
+	t.w.lock.Lock()
+	defer t.w.lock.Unlock()
+	return append([]Entry(nil), t.w.entries...)
+}
+
+// Count returns number of captured messages of specified kind.
+func (t *TestLogger) Count(kind MessageKind) int {
+	ret := 0
+	for _, e := range t.Entries() {
+		if e.Kind == kind {
+			ret++
+		}
+	}
+	return ret
+}
+
+// AssertNoErrors returns an error describing captured sql error messages, or nil
+// if there's none.
+func (t *TestLogger) AssertNoErrors() error {
+	var msgs []string
+	for _, e := range t.Entries() {
+		if e.Kind != KindError {
+			continue
+		}
+		errMsg, _ := e.Fields[zerolog.ErrorFieldName].(string)
+		msgs = append(msgs, fmt.Sprintf("%s: %s", e.Message, errMsg))
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d sql errors logged: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Reset drops captured messages.
+func (t *TestLogger) Reset() {
+	t.w.lock.Lock()
+	defer t.w.lock.Unlock()
+	t.w.entries = nil
+}
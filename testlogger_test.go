@@ -0,0 +1,92 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTestLogger(t *testing.T) {
+	l := NewTestLogger(Config{SlowThreshold: time.Minute})
+	db := openDB(t, l.Logger)
+
+	if err := db.Exec("SELECT 1").Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.AssertNoErrors(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	l.Trace(db.Statement.Context, time.Now().Add(-time.Hour), func() (string, int64) {
+		return "SELECT 2", 1
+	}, nil)
+	l.Trace(db.Statement.Context, time.Now(), func() (string, int64) {
+		return "SELECT 3", 1
+	}, errors.New("test"))
+
+	if x := l.Count(KindDump); x != 1 {
+		t.Errorf("expected 1 dump, got %d", x)
+	}
+	if x := l.Count(KindSlow); x != 1 {
+		t.Errorf("expected 1 slow, got %d", x)
+	}
+	if x := l.Count(KindError); x != 1 {
+		t.Errorf("expected 1 error, got %d", x)
+	}
+	if err := l.AssertNoErrors(); err == nil {
+		t.Error("expected an error")
+	}
+
+	l.Reset()
+	l.WarnMissingLimit = true
+	l.Trace(db.Statement.Context, time.Now(), func() (string, int64) {
+		return "SELECT * FROM users", 1
+	}, nil)
+	if x := l.Count(KindSlow); x != 1 {
+		t.Errorf("expected no limit message to be slow, got %d", x)
+	}
+
+	l.Reset()
+	if x := len(l.Entries()); x != 0 {
+		t.Errorf("expected no entries after reset, got %d", x)
+	}
+}
+
+func TestTestLoggerKind(t *testing.T) {
+	l := NewTestLogger(Config{OperationMessages: map[string]string{"SELECT": "query"}})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		return "SELECT 1", 1
+	}, nil)
+	l.Info(context.Background(), "sql query time exceeds threshold")
+
+	entries := l.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Kind != KindDump || e.Message != "query" {
+		t.Errorf("expected customized dump message, got %+v", e)
+	}
+	if _, ok := entries[0].Fields[kindKey]; ok {
+		t.Errorf("unexpected kind field: %v", entries[0].Fields)
+	}
+	if e := entries[1]; e.Kind != KindNone {
+		t.Errorf("expected message from gorm to be KindNone, got %+v", e)
+	}
+}
+
+func ExampleNewWithCapture() {
+	l, captured := NewWithCapture(Config{})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		return "SELECT 1", 1
+	}, nil)
+	fmt.Println(captured.Count(KindDump))
+	// Output:
+	// {"level":"debug","sql":"SELECT 1","affected_rows":1,"message":"dump sql"}
+	// 1
+}
@@ -0,0 +1,306 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Escalator tracks failed queries, and makes sql dumping messages visible for a
+// while if errors spike, so you have more context when diagnosing. Set it to
+// AutoEscalate of [Config] to use it. It is safe for concurrent use, and can be
+// shared between loggers.
+//
+// Errors are counted in fixed windows: if there are threshold or more errors
+// in a window, it escalates for a period of hold. Escalation is extended if
+// errors keep coming.
+type Escalator struct {
+	threshold int
+	window    time.Duration
+	hold      time.Duration
+
+	mu     sync.Mutex
+	start  time.Time // start of current window
+	errors int
+
+	until atomic.Int64 // escalated until, in unix nano
+}
+
+// NewEscalator creates an [Escalator], which escalates for hold if there are
+// threshold or more errors in window.
+func NewEscalator(threshold int, window, hold time.Duration) *Escalator {
+	return &Escalator{
+		threshold: threshold,
+		window:    window,
+		hold:      hold,
+	}
+}
+
+// fail records a failed query
+func (e *Escalator) fail(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if now.Sub(e.start) >= e.window {
+		e.start, e.errors = now, 0
+	}
+	e.errors++
+	if e.errors >= e.threshold {
+		e.until.Store(now.Add(e.hold).UnixNano())
+	}
+}
+
+// Reset drops counted errors and stops escalating. It is safe to call
+// concurrently with logging.
+func (e *Escalator) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.start, e.errors = time.Time{}, 0
+	e.until.Store(0)
+}
+
+// escalated reports if it is escalated
+func (e *Escalator) escalated(now time.Time) bool {
+	return now.UnixNano() < e.until.Load()
+}
+
+// max keys remembered by repeatCounter, oldest windows are dropped when full
+const maxRepeatKeys = 1024
+
+// repeatCounter counts occurrences of keys in fixed windows. Zero value is ready
+// to use.
+type repeatCounter struct {
+	mu   sync.Mutex
+	seen map[string]*repeatWindow
+}
+
+type repeatWindow struct {
+	start time.Time
+	count int
+}
+
+// add records an occurrence of key, and returns number of occurrences in
+// current window of key, including this one. A window starts at first
+// occurrence of key, and lasts for window.
+func (r *repeatCounter) add(key string, now time.Time, window time.Duration) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen == nil {
+		r.seen = map[string]*repeatWindow{}
+	}
+	w := r.seen[key]
+	if w == nil || now.Sub(w.start) >= window {
+		if w == nil && len(r.seen) >= maxRepeatKeys {
+			for k, v := range r.seen {
+				if now.Sub(v.start) >= window {
+					delete(r.seen, k)
+				}
+			}
+			if len(r.seen) >= maxRepeatKeys {
+				r.seen = map[string]*repeatWindow{}
+			}
+		}
+		w = &repeatWindow{start: now}
+		r.seen[key] = w
+	}
+	w.count++
+	return w.count
+}
+
+// reset drops all counted keys
+func (r *repeatCounter) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = nil
+}
+
+// LatencyTracker keeps durations of recent queries by fingerprint, which is sql
+// with literal values replaced, so queries different only in parameters are
+// tracked together. Set it to LatencyTracker of [Config] to use it. It is safe
+// for concurrent use, and can be shared between loggers.
+//
+// Memory is bounded: at most samples durations are kept for each fingerprint,
+// and at most fingerprints are tracked. A random fingerprint is forgotten if a
+// new one comes when full.
+type LatencyTracker struct {
+	samples int
+	max     int
+
+	mu    sync.Mutex
+	rings map[string]*latencyRing
+}
+
+// latencyRing is a ring buffer of durations
+type latencyRing struct {
+	durs []time.Duration
+	next int
+	slow int64 // number of slow queries
+}
+
+// default tracker used by AdaptiveSlow if LatencyTracker is not set
+var defaultTracker = NewLatencyTracker(128, 1024)
+
+// DefaultLatencyTracker returns the process-wide [LatencyTracker] used by
+// AdaptiveSlow in [Config] if LatencyTracker is not set, so you can call
+// [LatencyTracker.TopSlow] or [LatencyTracker.ReportTopSlow] with it.
+func DefaultLatencyTracker() *LatencyTracker { return defaultTracker }
+
+// NewLatencyTracker creates a [LatencyTracker] keeping samples durations for at
+// most fingerprints fingerprints. Both are at least 1.
+func NewLatencyTracker(samples, fingerprints int) *LatencyTracker {
+	samples, fingerprints = max(samples, 1), max(fingerprints, 1)
+	return &LatencyTracker{
+		samples: samples,
+		max:     fingerprints,
+		rings:   map[string]*latencyRing{},
+	}
+}
+
+// min samples of a fingerprint before AdaptiveSlow judges
+const minAdaptiveSamples = 20
+
+// record saves dur of fingerprint fp, and reports if it exceeds the p-th
+// percentile (0 to 1) of durations before it. Percentile is not checked if p is
+// negative or there are less than minAdaptiveSamples samples. Slow queries,
+// including those reported by fixed, are counted.
+func (t *LatencyTracker) record(fp string, dur time.Duration, fixed bool, p float64) (slow bool, limit time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.rings[fp]
+	if r == nil {
+		if len(t.rings) >= t.max {
+			for k := range t.rings {
+				delete(t.rings, k)
+				break
+			}
+		}
+		r = &latencyRing{durs: make([]time.Duration, 0, t.samples)}
+		t.rings[fp] = r
+	}
+
+	if p >= 0 && len(r.durs) >= minAdaptiveSamples {
+		limit = percentile(r.durs, p)
+		slow = dur > limit
+	}
+	if slow || fixed {
+		r.slow++
+	}
+	if len(r.durs) < t.samples {
+		r.durs = append(r.durs, dur)
+	} else {
+		r.durs[r.next] = dur
+		r.next = (r.next + 1) % t.samples
+	}
+	return
+}
+
+// Reset drops all recorded durations and slow counts, so AdaptiveSlow needs
+// samples again before judging. It is safe to call concurrently with logging.
+func (t *LatencyTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rings = map[string]*latencyRing{}
+}
+
+// FingerprintStat is statistics of queries with same fingerprint.
+type FingerprintStat struct {
+	Fingerprint string
+	// Number of slow queries.
+	SlowCount int64
+	// 95th percentile of recent durations.
+	P95 time.Duration
+}
+
+// TopSlow returns stats of at most n fingerprints with most slow queries, in
+// descending order. Fingerprints without slow queries are omitted. Nothing is
+// returned if n is 0 or less.
+func (t *LatencyTracker) TopSlow(n int) []FingerprintStat {
+	if n <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	ret := make([]FingerprintStat, 0, len(t.rings))
+	for fp, r := range t.rings {
+		if r.slow == 0 {
+			continue
+		}
+		ret = append(ret, FingerprintStat{
+			Fingerprint: fp,
+			SlowCount:   r.slow,
+			P95:         percentile(r.durs, 0.95),
+		})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SlowCount != ret[j].SlowCount {
+			return ret[i].SlowCount > ret[j].SlowCount
+		}
+		return ret[i].Fingerprint < ret[j].Fingerprint
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// ReportTopSlow logs result of [LatencyTracker.TopSlow] to l at Warn level every
+// period, until ctx is done. Nothing is logged if there's no slow query. Counts
+// are accumulated since the tracker is created. It returns immediately if n or
+// period is 0 or less. Run it in a goroutine:
+//
+//	go tracker.ReportTopSlow(ctx, log.Logger, 10, time.Hour)
+//
+// Use [DefaultLatencyTracker] if you set AdaptiveSlow without LatencyTracker.
+func (t *LatencyTracker) ReportTopSlow(ctx context.Context, l zerolog.Logger, n int, period time.Duration) {
+	if n <= 0 || period <= 0 {
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats := t.TopSlow(n)
+		if len(stats) == 0 {
+			continue
+		}
+		arr := zerolog.Arr()
+		for _, s := range stats {
+			arr.Dict(zerolog.Dict().
+				Str("fingerprint", s.Fingerprint).
+				Int64("slow_count", s.SlowCount).
+				Dur("p95", s.P95))
+		}
+		l.Warn().Array("top_slow", arr).Msg("top slow queries")
+	}
+}
+
+// percentile computes the p-th percentile (0 to 1) of non-empty durs
+func percentile(durs []time.Duration, p float64) time.Duration {
+	arr := append([]time.Duration(nil), durs...)
+	sort.Slice(arr, func(i, j int) bool { return arr[i] < arr[j] })
+	idx := int(math.Ceil(p*float64(len(arr)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(arr) {
+		idx = len(arr) - 1
+	}
+	return arr[idx]
+}
@@ -0,0 +1,118 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEscalator(t *testing.T) {
+	e := NewEscalator(2, time.Second, time.Minute)
+	now := time.Now()
+
+	e.fail(now)
+	if e.escalated(now) {
+		t.Fatal("unexpected escalation with 1 error")
+	}
+
+	// new window
+	e.fail(now.Add(time.Second))
+	if e.escalated(now.Add(time.Second)) {
+		t.Fatal("unexpected escalation with errors in different windows")
+	}
+
+	e.fail(now.Add(1500 * time.Millisecond))
+	if !e.escalated(now.Add(2 * time.Second)) {
+		t.Fatal("expected to escalate")
+	}
+	if e.escalated(now.Add(time.Minute + 2*time.Second)) {
+		t.Fatal("expected to revert after hold")
+	}
+}
+
+func TestAdaptiveSlow(t *testing.T) {
+	l, buf := bufLogger(Config{
+		AdaptiveSlow:   true,
+		LatencyTracker: NewLatencyTracker(32, 8),
+		DumpLevel:      Ignore,
+	})
+	trace := func(sql string, dur time.Duration) {
+		l.Trace(context.Background(), time.Now().Add(-dur), func() (string, int64) { return sql, 1 }, nil)
+	}
+
+	for i := 0; i < minAdaptiveSamples; i++ {
+		trace(fmt.Sprintf("SELECT * FROM users WHERE id = %d", i), time.Millisecond)
+	}
+	// other fingerprint without enough samples
+	trace("SELECT * FROM orders", time.Hour)
+	trace("SELECT * FROM users WHERE id = 100", time.Hour)
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["sql"] != "SELECT * FROM users WHERE id = 100" {
+		t.Errorf("unexpected slow message: %v", lines[0])
+	}
+}
+
+func TestTopSlow(t *testing.T) {
+	tracker := NewLatencyTracker(16, 16)
+	l, _ := bufLogger(Config{SlowThreshold: time.Second, LatencyTracker: tracker})
+	trace := func(sql string, dur time.Duration) {
+		l.Trace(context.Background(), time.Now().Add(-dur), func() (string, int64) { return sql, 1 }, nil)
+	}
+
+	for i := 0; i < 3; i++ {
+		trace(fmt.Sprintf("SELECT * FROM users WHERE id = %d", i), time.Minute)
+	}
+	trace("SELECT * FROM orders", time.Minute)
+	trace("SELECT * FROM items", time.Millisecond)
+
+	stats := tracker.TopSlow(5)
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 stats, got %v", stats)
+	}
+	if s := stats[0]; s.Fingerprint != "SELECT * FROM users WHERE id = ?" || s.SlowCount != 3 || s.P95 < time.Minute {
+		t.Errorf("unexpected first stat: %+v", s)
+	}
+	if s := stats[1]; s.Fingerprint != "SELECT * FROM orders" || s.SlowCount != 1 {
+		t.Errorf("unexpected second stat: %+v", s)
+	}
+	if x := tracker.TopSlow(1); len(x) != 1 {
+		t.Errorf("expected 1 stat, got %v", x)
+	}
+	for _, n := range []int{0, -1} {
+		if x := tracker.TopSlow(n); len(x) != 0 {
+			t.Errorf("expected no stat for %d, got %v", n, x)
+		}
+	}
+	// returns immediately instead of panicking
+	tracker.ReportTopSlow(context.Background(), zerolog.Nop(), 5, 0)
+}
+
+func TestResetStats(t *testing.T) {
+	tracker := NewLatencyTracker(16, 16)
+	esc := NewEscalator(1, time.Minute, time.Hour)
+	l, _ := bufLogger(Config{SlowThreshold: time.Second, LatencyTracker: tracker, AutoEscalate: esc})
+	l.Trace(context.Background(), time.Now().Add(-time.Minute), func() (string, int64) { return "SELECT 1", 1 }, errors.New("x"))
+	if len(tracker.TopSlow(1)) != 1 || !esc.escalated(time.Now()) {
+		t.Fatal("expected recorded state")
+	}
+
+	l.ResetStats()
+	if x := tracker.TopSlow(1); len(x) != 0 {
+		t.Errorf("expected no stat, got %v", x)
+	}
+	if esc.escalated(time.Now()) {
+		t.Error("expected not escalated")
+	}
+}
@@ -0,0 +1,255 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// levelFilter writes only messages at or above specified level. Messages without
+// level are dropped.
+type levelFilter struct {
+	io.Writer
+	min zerolog.Level
+}
+
+func (w levelFilter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w levelFilter) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	if l < w.min || l == zerolog.NoLevel || l == zerolog.Disabled {
+		return len(p), nil
+	}
+	return w.Writer.Write(p)
+}
+
+// Tee creates a [zerolog.LevelWriter] which writes every message to writers, and
+// messages at Error level or above to errw additionally. Messages without level,
+// like those of [zerolog.Logger.Log], are not written to errw.
+//
+// A common setup is to log everything to stdout, and errors to another sink so
+// they can be collected separately:
+//
+//	w := Tee(errFile, os.Stdout)
+//	db, err := gorm.Open(dialector, &gorm.Config{
+//		Logger: &Logger{Logger: zerolog.New(w).With().Timestamp().Logger()},
+//	})
+//
+// Level of a message is decided by the event, so the filter works as expected
+// with [Config] like ErrorLevel or SlowLevel.
+func Tee(errw io.Writer, writers ...io.Writer) zerolog.LevelWriter {
+	arr := make([]io.Writer, 0, len(writers)+1)
+	arr = append(arr, writers...)
+	arr = append(arr, levelFilter{Writer: errw, min: zerolog.ErrorLevel})
+	return zerolog.MultiLevelWriter(arr...)
+}
+
+// LineLimiter wraps w to trim messages longer than max bytes, by truncating sql
+// (and sql_template of LogBothSQL) and adding "truncated" field set to true, so
+// log backends limiting line size do not drop them. Keys and truncation are
+// decided by c, like SQL and TruncateMarker.
+//
+// Wrap your output with it:
+//
+//	c := Config{LogBothSQL: true}
+//	l := &Logger{
+//		Logger: zerolog.New(LineLimiter(os.Stdout, 4096, c)),
+//		Config: c,
+//	}
+//
+// Lines still exceeding max after sql is emptied are written as is. It works
+// only for json output, and costs re-encoding of long lines.
+//
+// It is a writer instead of an option of [Config], as size of a line is known
+// only when it is written, and zerolog does not expose the writer of a logger.
+func LineLimiter(w io.Writer, max int, c Config) zerolog.LevelWriter {
+	lw, ok := w.(zerolog.LevelWriter)
+	if !ok {
+		lw = zerolog.LevelWriterAdapter{Writer: w}
+	}
+	return &lineLimiter{w: lw, max: max, c: &c}
+}
+
+// lineLimiter trims sql in lines longer than max bytes, see LineLimiter.
+type lineLimiter struct {
+	w   zerolog.LevelWriter
+	max int
+	c   *Config
+}
+
+func (w *lineLimiter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *lineLimiter) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	if len(p) <= w.max {
+		return w.w.WriteLevel(l, p)
+	}
+	if _, err := w.w.WriteLevel(l, w.c.trimLine(p, w.max)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// a field of json object
+type jsonField struct {
+	key string
+	val json.RawMessage
+}
+
+// encodes fields into a json line
+func encodeLine(fields []jsonField) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(f.val)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// trimLine truncates sql fields of a json line so it fits in max bytes, and adds
+// "truncated" field. Line is returned as is if it is not a json object.
+func (c *Config) trimLine(p []byte, max int) []byte {
+	var fields []jsonField
+	dec := json.NewDecoder(bytes.NewReader(p))
+	if _, err := dec.Token(); err != nil {
+		return p
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return p
+		}
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			return p
+		}
+		k, _ := tok.(string)
+		fields = append(fields, jsonField{key: k, val: val})
+	}
+	fields = append(fields, jsonField{key: "truncated", val: json.RawMessage("true")})
+
+	enc := func(s string) json.RawMessage {
+		buf := &bytes.Buffer{}
+		e := json.NewEncoder(buf)
+		e.SetEscapeHTML(false)
+		e.Encode(s)
+		return bytes.TrimSpace(buf.Bytes())
+	}
+	sqlKey := c.sqlKey()
+	ret := encodeLine(fields)
+	// template first as it is less important
+	for _, k := range []string{"sql_template", sqlKey} {
+		for i := range fields {
+			excess := len(ret) - max
+			if fields[i].key != k || excess <= 0 {
+				continue
+			}
+			var s string
+			if json.Unmarshal(fields[i].val, &s) != nil {
+				continue
+			}
+			n := len(s) - excess - len(key(c.TruncateMarker, "…"))
+			if n < 0 {
+				n = 0
+			}
+			fields[i].val = enc(c.truncate(s, n))
+			ret = encodeLine(fields)
+		}
+	}
+	return ret
+}
+
+// quotes s for logfmt if needed
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// LogfmtLogger creates a [Logger] writing messages to w in logfmt, like
+//
+//	level=debug sql="SELECT 1" affected_rows=1 msg="dump sql"
+//
+// It uses [zerolog.ConsoleWriter] without color, and is intended to be set up
+// once. Level and timestamp come first, message comes last. Other fields are
+// sorted by name, and error field is placed in front of them. Values which are
+// not strings or numbers, like arrays, are written as quoted json.
+//
+// Add timestamp by yourself if you need it:
+//
+//	l := LogfmtLogger(os.Stdout, Config{})
+//	l.Logger = l.Logger.With().Timestamp().Logger()
+func LogfmtLogger(w io.Writer, c Config) *Logger {
+	cw := zerolog.ConsoleWriter{
+		Out:        w,
+		NoColor:    true,
+		TimeFormat: time.RFC3339,
+		// message is written after fields by FormatExtra
+		PartsOrder: []string{zerolog.TimestampFieldName, zerolog.LevelFieldName},
+		FormatTimestamp: func(i any) string {
+			if i == nil {
+				return ""
+			}
+			return zerolog.TimestampFieldName + "=" + logfmtValue(fmt.Sprint(i))
+		},
+		FormatLevel: func(i any) string {
+			if i == nil {
+				return ""
+			}
+			return zerolog.LevelFieldName + "=" + logfmtValue(fmt.Sprint(i))
+		},
+		FormatFieldName:     func(i any) string { return fmt.Sprint(i) + "=" },
+		FormatErrFieldName:  func(i any) string { return fmt.Sprint(i) + "=" },
+		FormatFieldValue:    logfmtField,
+		FormatErrFieldValue: logfmtField,
+		FormatExtra: func(evt map[string]any, buf *bytes.Buffer) error {
+			if msg, ok := evt[zerolog.MessageFieldName]; ok {
+				if buf.Len() > 0 {
+					buf.WriteByte(' ')
+				}
+				buf.WriteString("msg=" + logfmtValue(fmt.Sprint(msg)))
+			}
+			return nil
+		},
+	}
+
+	return &Logger{
+		Logger: zerolog.New(cw),
+		Config: c,
+	}
+}
+
+// formats field values for logfmt. ConsoleWriter quotes strings with special
+// characters but "=", so quoted ones are kept and others are quoted as needed.
+func logfmtField(i any) string {
+	switch v := i.(type) {
+	case []byte:
+		return logfmtValue(string(v))
+	case string:
+		if strings.HasPrefix(v, `"`) {
+			return v
+		}
+		return logfmtValue(v)
+	}
+	return fmt.Sprint(i)
+}
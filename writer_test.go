@@ -0,0 +1,52 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package gorm0log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogfmtLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := LogfmtLogger(buf, Config{LogErrorChain: true})
+	l.Trace(context.Background(), time.Now(), func() (string, int64) {
+		return "SELECT * FROM users WHERE name = \"x\"", 1
+	}, errors.New("bad thing"))
+
+	expect := `level=error error="bad thing" affected_rows=1 error_chain="[\"bad thing\"]" sql="SELECT * FROM users WHERE name = \"x\"" msg="a sql error occurred"` + "\n"
+	if actual := buf.String(); actual != expect {
+		t.Errorf("expected %s, got %s", expect, actual)
+	}
+
+	buf.Reset()
+	l.Trace(context.Background(), time.Now(), func() (string, int64) { return "a=b", -1 }, nil)
+	expect = `level=debug sql="a=b" msg="dump sql"` + "\n"
+	if actual := buf.String(); actual != expect {
+		t.Errorf("expected %s, got %s", expect, actual)
+	}
+}
+
+func TestTee(t *testing.T) {
+	errw, out := &bytes.Buffer{}, &bytes.Buffer{}
+	l := zerolog.New(Tee(errw, out))
+	l.Error().Msg("bad")
+	l.Warn().Msg("warn")
+	l.Log().Msg("no level")
+	l.Write([]byte("raw\n"))
+
+	if n := strings.Count(out.String(), "\n"); n != 4 {
+		t.Errorf("expected 4 lines in out, got %s", out.String())
+	}
+	if actual := errw.String(); actual != `{"level":"error","message":"bad"}`+"\n" {
+		t.Errorf("unexpected error sink: %s", actual)
+	}
+}